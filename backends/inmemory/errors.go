@@ -7,3 +7,5 @@ import "errors"
 // ErrCacheMiss indicates a cache miss.
 var ErrCacheMiss = errors.New("cache miss")
 
+// ErrUnsupportedEvictionPolicy indicates an unknown eviction policy name.
+var ErrUnsupportedEvictionPolicy = errors.New("unsupported eviction policy")
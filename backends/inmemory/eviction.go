@@ -0,0 +1,353 @@
+// File: eviction.go
+
+package inmemory
+
+import (
+	"container/list"
+	"math/rand"
+)
+
+// EvictionPolicy decides which key to remove when a bounded cache is full.
+// Implementations are not safe for concurrent use; the owning cache calls
+// them while holding its own lock.
+type EvictionPolicy = TypedEvictionPolicy[string]
+
+// TypedEvictionPolicy is the generic form of EvictionPolicy for keys of type K.
+type TypedEvictionPolicy[K comparable] interface {
+	// OnSet records that key was inserted or updated.
+	OnSet(key K)
+	// OnGet records a successful read of key.
+	OnGet(key K)
+	// OnDelete forgets key.
+	OnDelete(key K)
+	// Evict selects and forgets a victim. It returns false if no keys are tracked.
+	Evict() (K, bool)
+	// Reset forgets all keys.
+	Reset()
+}
+
+// EvictionPolicyFactory creates a fresh EvictionPolicy, one per shard.
+type EvictionPolicyFactory = TypedEvictionPolicyFactory[string]
+
+// TypedEvictionPolicyFactory creates a fresh TypedEvictionPolicy, one per shard.
+type TypedEvictionPolicyFactory[K comparable] func() TypedEvictionPolicy[K]
+
+// NewEvictionPolicyFactory returns the factory for a named policy: "lru",
+// "lfu", "fifo" or "random". An empty name selects "lru".
+func NewEvictionPolicyFactory(name string) (EvictionPolicyFactory, error) {
+	return NewTypedEvictionPolicyFactory[string](name)
+}
+
+// NewTypedEvictionPolicyFactory is the generic form of NewEvictionPolicyFactory.
+func NewTypedEvictionPolicyFactory[K comparable](name string) (TypedEvictionPolicyFactory[K], error) {
+	switch name {
+	case "", "lru":
+		return func() TypedEvictionPolicy[K] { return NewTypedLRUPolicy[K]() }, nil
+	case "lfu":
+		return func() TypedEvictionPolicy[K] { return NewTypedLFUPolicy[K]() }, nil
+	case "fifo":
+		return func() TypedEvictionPolicy[K] { return NewTypedFIFOPolicy[K]() }, nil
+	case "random":
+		return func() TypedEvictionPolicy[K] { return NewTypedRandomPolicy[K]() }, nil
+	default:
+		return nil, ErrUnsupportedEvictionPolicy
+	}
+}
+
+// LRUPolicy evicts the least recently used key.
+type LRUPolicy = TypedLRUPolicy[string]
+
+// TypedLRUPolicy is the generic form of LRUPolicy for keys of type K.
+type TypedLRUPolicy[K comparable] struct {
+	order *list.List
+	items map[K]*list.Element
+}
+
+// NewLRUPolicy creates an empty LRUPolicy.
+func NewLRUPolicy() *LRUPolicy {
+	return NewTypedLRUPolicy[string]()
+}
+
+// NewTypedLRUPolicy creates an empty TypedLRUPolicy.
+func NewTypedLRUPolicy[K comparable]() *TypedLRUPolicy[K] {
+	return &TypedLRUPolicy[K]{
+		order: list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+// OnSet marks key as the most recently used.
+func (p *TypedLRUPolicy[K]) OnSet(key K) {
+	if elem, ok := p.items[key]; ok {
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.items[key] = p.order.PushFront(key)
+}
+
+// OnGet marks key as the most recently used.
+func (p *TypedLRUPolicy[K]) OnGet(key K) {
+	if elem, ok := p.items[key]; ok {
+		p.order.MoveToFront(elem)
+	}
+}
+
+// OnDelete forgets key.
+func (p *TypedLRUPolicy[K]) OnDelete(key K) {
+	if elem, ok := p.items[key]; ok {
+		p.order.Remove(elem)
+		delete(p.items, key)
+	}
+}
+
+// Evict removes and returns the least recently used key.
+func (p *TypedLRUPolicy[K]) Evict() (K, bool) {
+	elem := p.order.Back()
+	if elem == nil {
+		var zero K
+		return zero, false
+	}
+	key := p.order.Remove(elem).(K)
+	delete(p.items, key)
+	return key, true
+}
+
+// Reset forgets all keys.
+func (p *TypedLRUPolicy[K]) Reset() {
+	p.order.Init()
+	p.items = make(map[K]*list.Element)
+}
+
+// FIFOPolicy evicts keys in insertion order; reads and updates do not
+// change a key's position.
+type FIFOPolicy = TypedFIFOPolicy[string]
+
+// TypedFIFOPolicy is the generic form of FIFOPolicy for keys of type K.
+type TypedFIFOPolicy[K comparable] struct {
+	order *list.List
+	items map[K]*list.Element
+}
+
+// NewFIFOPolicy creates an empty FIFOPolicy.
+func NewFIFOPolicy() *FIFOPolicy {
+	return NewTypedFIFOPolicy[string]()
+}
+
+// NewTypedFIFOPolicy creates an empty TypedFIFOPolicy.
+func NewTypedFIFOPolicy[K comparable]() *TypedFIFOPolicy[K] {
+	return &TypedFIFOPolicy[K]{
+		order: list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+// OnSet enqueues key if it is not already tracked.
+func (p *TypedFIFOPolicy[K]) OnSet(key K) {
+	if _, ok := p.items[key]; ok {
+		return
+	}
+	p.items[key] = p.order.PushFront(key)
+}
+
+// OnGet is a no-op for FIFO.
+func (p *TypedFIFOPolicy[K]) OnGet(key K) {}
+
+// OnDelete forgets key.
+func (p *TypedFIFOPolicy[K]) OnDelete(key K) {
+	if elem, ok := p.items[key]; ok {
+		p.order.Remove(elem)
+		delete(p.items, key)
+	}
+}
+
+// Evict removes and returns the oldest key.
+func (p *TypedFIFOPolicy[K]) Evict() (K, bool) {
+	elem := p.order.Back()
+	if elem == nil {
+		var zero K
+		return zero, false
+	}
+	key := p.order.Remove(elem).(K)
+	delete(p.items, key)
+	return key, true
+}
+
+// Reset forgets all keys.
+func (p *TypedFIFOPolicy[K]) Reset() {
+	p.order.Init()
+	p.items = make(map[K]*list.Element)
+}
+
+// LFUPolicy evicts the least frequently used key, breaking ties by evicting
+// the least recently used key within the lowest frequency.
+type LFUPolicy = TypedLFUPolicy[string]
+
+// TypedLFUPolicy is the generic form of LFUPolicy for keys of type K.
+type TypedLFUPolicy[K comparable] struct {
+	items   map[K]*lfuEntry
+	buckets map[int]*list.List
+	minFreq int
+}
+
+type lfuEntry struct {
+	freq int
+	elem *list.Element
+}
+
+// NewLFUPolicy creates an empty LFUPolicy.
+func NewLFUPolicy() *LFUPolicy {
+	return NewTypedLFUPolicy[string]()
+}
+
+// NewTypedLFUPolicy creates an empty TypedLFUPolicy.
+func NewTypedLFUPolicy[K comparable]() *TypedLFUPolicy[K] {
+	return &TypedLFUPolicy[K]{
+		items:   make(map[K]*lfuEntry),
+		buckets: make(map[int]*list.List),
+	}
+}
+
+// OnSet tracks a new key with frequency one, or counts an update as an access.
+func (p *TypedLFUPolicy[K]) OnSet(key K) {
+	if _, ok := p.items[key]; ok {
+		p.increment(key)
+		return
+	}
+	p.items[key] = &lfuEntry{freq: 1, elem: p.bucket(1).PushFront(key)}
+	p.minFreq = 1
+}
+
+// OnGet increments the access frequency of key.
+func (p *TypedLFUPolicy[K]) OnGet(key K) {
+	if _, ok := p.items[key]; ok {
+		p.increment(key)
+	}
+}
+
+// OnDelete forgets key.
+func (p *TypedLFUPolicy[K]) OnDelete(key K) {
+	entry, ok := p.items[key]
+	if !ok {
+		return
+	}
+	p.removeFromBucket(entry)
+	delete(p.items, key)
+}
+
+// Evict removes and returns the least frequently used key.
+func (p *TypedLFUPolicy[K]) Evict() (K, bool) {
+	if len(p.items) == 0 {
+		var zero K
+		return zero, false
+	}
+	bucket, ok := p.buckets[p.minFreq]
+	for !ok || bucket.Len() == 0 {
+		// minFreq can go stale after deletes; advance to the next populated bucket.
+		p.minFreq++
+		bucket, ok = p.buckets[p.minFreq]
+	}
+	key := bucket.Back().Value.(K)
+	p.OnDelete(key)
+	return key, true
+}
+
+// Reset forgets all keys.
+func (p *TypedLFUPolicy[K]) Reset() {
+	p.items = make(map[K]*lfuEntry)
+	p.buckets = make(map[int]*list.List)
+	p.minFreq = 0
+}
+
+func (p *TypedLFUPolicy[K]) bucket(freq int) *list.List {
+	bucket, ok := p.buckets[freq]
+	if !ok {
+		bucket = list.New()
+		p.buckets[freq] = bucket
+	}
+	return bucket
+}
+
+func (p *TypedLFUPolicy[K]) removeFromBucket(entry *lfuEntry) {
+	bucket := p.buckets[entry.freq]
+	bucket.Remove(entry.elem)
+	if bucket.Len() == 0 {
+		delete(p.buckets, entry.freq)
+	}
+}
+
+func (p *TypedLFUPolicy[K]) increment(key K) {
+	entry := p.items[key]
+	p.removeFromBucket(entry)
+	if entry.freq == p.minFreq {
+		if _, ok := p.buckets[entry.freq]; !ok {
+			p.minFreq++
+		}
+	}
+	entry.freq++
+	entry.elem = p.bucket(entry.freq).PushFront(key)
+}
+
+// RandomPolicy evicts a uniformly random key.
+type RandomPolicy = TypedRandomPolicy[string]
+
+// TypedRandomPolicy is the generic form of RandomPolicy for keys of type K.
+type TypedRandomPolicy[K comparable] struct {
+	keys  []K
+	index map[K]int
+	rng   *rand.Rand
+}
+
+// NewRandomPolicy creates an empty RandomPolicy.
+func NewRandomPolicy() *RandomPolicy {
+	return NewTypedRandomPolicy[string]()
+}
+
+// NewTypedRandomPolicy creates an empty TypedRandomPolicy.
+func NewTypedRandomPolicy[K comparable]() *TypedRandomPolicy[K] {
+	return &TypedRandomPolicy[K]{
+		index: make(map[K]int),
+		rng:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// OnSet tracks key.
+func (p *TypedRandomPolicy[K]) OnSet(key K) {
+	if _, ok := p.index[key]; ok {
+		return
+	}
+	p.index[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+}
+
+// OnGet is a no-op for random eviction.
+func (p *TypedRandomPolicy[K]) OnGet(key K) {}
+
+// OnDelete forgets key by swapping it with the last tracked key.
+func (p *TypedRandomPolicy[K]) OnDelete(key K) {
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	last := len(p.keys) - 1
+	p.keys[i] = p.keys[last]
+	p.index[p.keys[i]] = i
+	p.keys = p.keys[:last]
+	delete(p.index, key)
+}
+
+// Evict removes and returns a random key.
+func (p *TypedRandomPolicy[K]) Evict() (K, bool) {
+	if len(p.keys) == 0 {
+		var zero K
+		return zero, false
+	}
+	key := p.keys[p.rng.Intn(len(p.keys))]
+	p.OnDelete(key)
+	return key, true
+}
+
+// Reset forgets all keys.
+func (p *TypedRandomPolicy[K]) Reset() {
+	p.keys = nil
+	p.index = make(map[K]int)
+}
@@ -0,0 +1,88 @@
+// File: eviction_test.go
+
+package inmemory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUPolicy(t *testing.T) {
+	cache := NewBoundedRWMutexCache(time.Minute, 2, NewLRUPolicy())
+
+	cache.Set("key1", "value1")
+	cache.Set("key2", "value2")
+	cache.Get("key1")
+	cache.Set("key3", "value3")
+
+	if _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected key2 to be evicted")
+	}
+	if _, err := cache.Get("key1"); err != nil {
+		t.Errorf("Expected key1 to survive, error: %v", err)
+	}
+}
+
+func TestLFUPolicy(t *testing.T) {
+	cache := NewBoundedRWMutexCache(time.Minute, 2, NewLFUPolicy())
+
+	cache.Set("key1", "value1")
+	cache.Set("key2", "value2")
+	cache.Get("key1")
+	cache.Get("key1")
+	cache.Get("key2")
+	cache.Set("key3", "value3")
+
+	if _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected key2 to be evicted")
+	}
+	if _, err := cache.Get("key1"); err != nil {
+		t.Errorf("Expected key1 to survive, error: %v", err)
+	}
+}
+
+func TestFIFOPolicy(t *testing.T) {
+	cache := NewBoundedRWMutexCache(time.Minute, 2, NewFIFOPolicy())
+
+	cache.Set("key1", "value1")
+	cache.Set("key2", "value2")
+	cache.Get("key1")
+	cache.Set("key3", "value3")
+
+	if _, err := cache.Get("key1"); err == nil {
+		t.Errorf("Expected key1 to be evicted")
+	}
+	if _, err := cache.Get("key2"); err != nil {
+		t.Errorf("Expected key2 to survive, error: %v", err)
+	}
+}
+
+func TestRandomPolicy(t *testing.T) {
+	cache := NewBoundedRWMutexCache(time.Minute, 10, NewRandomPolicy())
+
+	for i := 0; i < 100; i++ {
+		cache.Set(string(rune('a'+i%26))+string(rune('a'+i/26)), i)
+	}
+	if cache.Len() != 10 {
+		t.Errorf("Expected 10 entries, got %d", cache.Len())
+	}
+}
+
+func TestShardedCacheCapacity(t *testing.T) {
+	newPolicy, err := NewEvictionPolicyFactory("lru")
+	if err != nil {
+		t.Fatalf("Failed to create policy factory: %v", err)
+	}
+	cache := NewShardedCacheWithPolicy(4, time.Minute, 5, newPolicy)
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(string(rune(i)), i)
+	}
+	if cache.Len() > 20 {
+		t.Errorf("Expected at most 20 entries, got %d", cache.Len())
+	}
+
+	if _, err := NewEvictionPolicyFactory("unknown"); err != ErrUnsupportedEvictionPolicy {
+		t.Errorf("Expected ErrUnsupportedEvictionPolicy, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// File: hash.go
+
+package inmemory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// hashKey returns a 64-bit FNV-1a hash of key. Strings and integers are hashed
+// directly; other comparable key types are hashed through their Go syntax
+// representation.
+func hashKey[K comparable](key K) uint64 {
+	hasher := fnv.New64a()
+	var buf [8]byte
+	switch k := interface{}(key).(type) {
+	case string:
+		hasher.Write([]byte(k))
+	case int:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		hasher.Write(buf[:])
+	case int64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		hasher.Write(buf[:])
+	case int32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		hasher.Write(buf[:])
+	case uint:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		hasher.Write(buf[:])
+	case uint64:
+		binary.LittleEndian.PutUint64(buf[:], k)
+		hasher.Write(buf[:])
+	case uint32:
+		binary.LittleEndian.PutUint64(buf[:], uint64(k))
+		hasher.Write(buf[:])
+	default:
+		fmt.Fprintf(hasher, "%#v", key)
+	}
+	return hasher.Sum64()
+}
@@ -0,0 +1,78 @@
+// File: janitor.go
+
+package inmemory
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// expireSampleSize is the number of entries examined per locked sweep step.
+	expireSampleSize = 20
+	// expireCycleBudget bounds how long a single sweep of one cache or shard may run.
+	expireCycleBudget = 5 * time.Millisecond
+)
+
+// sweepFunc examines up to sample entries, removes the expired ones, and
+// reports how many entries it examined and how many it removed.
+type sweepFunc func(sample int) (sampled, expired int)
+
+// activeExpire reclaims expired entries in the style of Redis's active expiry:
+// it keeps sampling while more than a quarter of each sample was expired, so
+// that locks are only ever held for one small sample at a time.
+func activeExpire(sweep sweepFunc) {
+	deadline := time.Now().Add(expireCycleBudget)
+	for {
+		sampled, expired := sweep(expireSampleSize)
+		if sampled == 0 || expired*4 <= sampled || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+// expiryJanitor runs a sweep on a fixed interval in a background goroutine.
+type expiryJanitor struct {
+	mutex sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// start launches the background goroutine, replacing any running one.
+func (j *expiryJanitor) start(interval time.Duration, sweep func()) {
+	j.halt()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	j.stop, j.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// halt stops the background goroutine, if any, and waits for it to exit.
+func (j *expiryJanitor) halt() {
+	j.mutex.Lock()
+	stop, done := j.stop, j.done
+	j.stop, j.done = nil, nil
+	j.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
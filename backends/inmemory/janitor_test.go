@@ -0,0 +1,54 @@
+// File: janitor_test.go
+
+package inmemory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	caches := map[string]interface {
+		Set(key string, value interface{}) error
+		StartJanitor(interval time.Duration)
+		Close() error
+	}{
+		"rwmutex": NewRWMutexCache(50 * time.Millisecond),
+		"sharded": NewShardedCache(4, 50*time.Millisecond, 1000),
+		"tinylfu": NewTinyLFUCache(50*time.Millisecond, 1000),
+	}
+
+	for name, cache := range caches {
+		cache.StartJanitor(10 * time.Millisecond)
+		for i := 0; i < 500; i++ {
+			cache.Set(fmt.Sprintf("key%d", i), i)
+		}
+		time.Sleep(500 * time.Millisecond)
+		cache.Close()
+
+		if n := cache.(interface{ Len() int }).Len(); n != 0 {
+			t.Errorf("%s: expected expired entries to be reclaimed, %d remain", name, n)
+		}
+	}
+}
+
+func TestSyncMapCacheJanitor(t *testing.T) {
+	cache := NewSyncMapCache(50 * time.Millisecond)
+	cache.StartJanitor(10 * time.Millisecond)
+	defer cache.Close()
+
+	for i := 0; i < 500; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), i)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	remaining := 0
+	cache.data.Range(func(key, value interface{}) bool {
+		remaining++
+		return true
+	})
+	if remaining != 0 {
+		t.Errorf("Expected expired entries to be reclaimed, %d remain", remaining)
+	}
+}
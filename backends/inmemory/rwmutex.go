@@ -3,68 +3,256 @@
 package inmemory
 
 import (
+	"io"
 	"sync"
 	"time"
+
+	"cachefy/serialization"
 )
 
 // RWMutexCache is an in-memory cache implementation with RWMutex for thread safety.
-type RWMutexCache struct {
-	data       map[string]cacheItem
+type RWMutexCache = TypedRWMutexCache[string, interface{}]
+
+// TypedRWMutexCache is the generic form of RWMutexCache for keys of type K and values of type V.
+type TypedRWMutexCache[K comparable, V any] struct {
+	data       map[K]cacheItem[V]
 	mutex      sync.RWMutex
 	defaultTTL time.Duration
+	capacity   int
+	policy     TypedEvictionPolicy[K]
+	janitor    expiryJanitor
 }
 
-type cacheItem struct {
-	value     interface{}
+type cacheItem[V any] struct {
+	value     V
 	expiresAt time.Time
 }
 
 // NewRWMutexCache creates a new RWMutexCache instance with the provided default TTL.
 func NewRWMutexCache(defaultTTL time.Duration) *RWMutexCache {
-	return &RWMutexCache{
-		data:       make(map[string]cacheItem),
+	return NewTypedRWMutexCache[string, interface{}](defaultTTL)
+}
+
+// NewBoundedRWMutexCache creates a RWMutexCache that holds at most capacity
+// entries, using policy to choose which entry to evict when full.
+func NewBoundedRWMutexCache(defaultTTL time.Duration, capacity int, policy EvictionPolicy) *RWMutexCache {
+	return NewBoundedTypedRWMutexCache[string, interface{}](defaultTTL, capacity, policy)
+}
+
+// NewTypedRWMutexCache creates a new TypedRWMutexCache instance with the provided default TTL.
+func NewTypedRWMutexCache[K comparable, V any](defaultTTL time.Duration) *TypedRWMutexCache[K, V] {
+	return &TypedRWMutexCache[K, V]{
+		data:       make(map[K]cacheItem[V]),
+		defaultTTL: defaultTTL,
+	}
+}
+
+// NewBoundedTypedRWMutexCache creates a TypedRWMutexCache that holds at most
+// capacity entries, using policy to choose which entry to evict when full.
+func NewBoundedTypedRWMutexCache[K comparable, V any](defaultTTL time.Duration, capacity int, policy TypedEvictionPolicy[K]) *TypedRWMutexCache[K, V] {
+	return &TypedRWMutexCache[K, V]{
+		data:       make(map[K]cacheItem[V], capacity),
 		defaultTTL: defaultTTL,
+		capacity:   capacity,
+		policy:     policy,
 	}
 }
 
 // Get retrieves the value associated with the given key.
-func (c *RWMutexCache) Get(key string) (interface{}, error) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+func (c *TypedRWMutexCache[K, V]) Get(key K) (V, error) {
+	if c.policy != nil {
+		// Recording the access mutates the policy, so a shared lock is not enough.
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	} else {
+		c.mutex.RLock()
+		defer c.mutex.RUnlock()
+	}
 
 	item, exists := c.data[key]
-	if !exists || time.Now().After(item.expiresAt) {
-		return nil, ErrCacheMiss
+	if !exists || isExpired(item.expiresAt, time.Now()) {
+		var zero V
+		return zero, ErrCacheMiss
+	}
+	if c.policy != nil {
+		c.policy.OnGet(key)
 	}
 	return item.value, nil
 }
 
 // Set stores the value associated with the given key.
-func (c *RWMutexCache) Set(key string, value interface{}) error {
+func (c *TypedRWMutexCache[K, V]) Set(key K, value V) error {
+	return c.SetWithTTL(key, value, c.defaultTTL)
+}
+
+// SetWithTTL stores the value associated with the given key for ttl.
+// A ttl <= 0 stores the value without expiry.
+func (c *TypedRWMutexCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.data[key] = cacheItem{
+	if c.policy != nil {
+		if _, exists := c.data[key]; !exists {
+			c.evictIfFull()
+		}
+		c.policy.OnSet(key)
+	}
+	c.data[key] = cacheItem[V]{
 		value:     value,
-		expiresAt: time.Now().Add(c.defaultTTL),
+		expiresAt: expiryFor(ttl),
+	}
+	return nil
+}
+
+// TTL returns the remaining lifetime of key, or interfaces.NoExpiration if it never expires.
+func (c *TypedRWMutexCache[K, V]) TTL(key K) (time.Duration, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	now := time.Now()
+	item, exists := c.data[key]
+	if !exists || isExpired(item.expiresAt, now) {
+		return 0, ErrCacheMiss
+	}
+	return remainingTTL(item.expiresAt, now), nil
+}
+
+// Touch resets the lifetime of an existing key to ttl. A ttl <= 0 removes its expiry.
+func (c *TypedRWMutexCache[K, V]) Touch(key K, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	item, exists := c.data[key]
+	if !exists || isExpired(item.expiresAt, time.Now()) {
+		return ErrCacheMiss
 	}
+	item.expiresAt = expiryFor(ttl)
+	c.data[key] = item
 	return nil
 }
 
+// Persist removes the expiry of an existing key.
+func (c *TypedRWMutexCache[K, V]) Persist(key K) error {
+	return c.Touch(key, 0)
+}
+
 // Delete removes the value associated with the given key.
-func (c *RWMutexCache) Delete(key string) error {
+func (c *TypedRWMutexCache[K, V]) Delete(key K) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	delete(c.data, key)
+	if c.policy != nil {
+		c.policy.OnDelete(key)
+	}
 	return nil
 }
 
 // Clear removes all entries from the cache.
-func (c *RWMutexCache) Clear() error {
+func (c *TypedRWMutexCache[K, V]) Clear() error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.data = make(map[string]cacheItem)
+	c.data = make(map[K]cacheItem[V])
+	if c.policy != nil {
+		c.policy.Reset()
+	}
 	return nil
 }
+
+// Len returns the number of stored entries, including expired ones not yet removed.
+func (c *TypedRWMutexCache[K, V]) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return len(c.data)
+}
+
+// Capacity returns the maximum number of entries, or zero if the cache is unbounded.
+func (c *TypedRWMutexCache[K, V]) Capacity() int {
+	return c.capacity
+}
+
+// HasCapacityFor reports whether key can be stored without evicting another entry.
+func (c *TypedRWMutexCache[K, V]) HasCapacityFor(key K) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	_, exists := c.data[key]
+	return c.capacity <= 0 || exists || len(c.data) < c.capacity
+}
+
+// Snapshot writes the live entries and their expiry times to w, encoded with
+// serializer; a nil serializer selects gob. It returns the number of entries written.
+func (c *TypedRWMutexCache[K, V]) Snapshot(w io.Writer, serializer serialization.Serializer) (int, error) {
+	c.mutex.RLock()
+	items := c.appendSnapshotItems(nil, time.Now())
+	c.mutex.RUnlock()
+
+	return writeSnapshot(w, serializer, items)
+}
+
+// Restore adds the unexpired entries of a snapshot written by Snapshot with the
+// same serializer, replacing existing entries with the same keys. Entries keep
+// the expiry times they had when the snapshot was taken. Nothing is restored
+// from a snapshot that is truncated or corrupt. It returns the number of
+// entries restored.
+func (c *TypedRWMutexCache[K, V]) Restore(r io.Reader, serializer serialization.Serializer) (int, error) {
+	return readSnapshot(r, serializer, c.SetWithTTL)
+}
+
+// appendSnapshotItems appends the entries that are live at now to items.
+// The caller must hold the lock.
+func (c *TypedRWMutexCache[K, V]) appendSnapshotItems(items []snapshotItem[K, V], now time.Time) []snapshotItem[K, V] {
+	for key, item := range c.data {
+		if !isExpired(item.expiresAt, now) {
+			items = append(items, snapshotItem[K, V]{key: key, value: item.value, expiresAt: item.expiresAt})
+		}
+	}
+	return items
+}
+
+// StartJanitor starts a background goroutine that removes expired entries
+// every interval. It runs until Close is called.
+func (c *TypedRWMutexCache[K, V]) StartJanitor(interval time.Duration) {
+	c.janitor.start(interval, func() { activeExpire(c.sweepExpired) })
+}
+
+// Close stops the background janitor, if one is running.
+func (c *TypedRWMutexCache[K, V]) Close() error {
+	c.janitor.halt()
+	return nil
+}
+
+// sweepExpired removes expired entries among a sample of up to sample entries.
+func (c *TypedRWMutexCache[K, V]) sweepExpired(sample int) (sampled, expired int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for key, item := range c.data {
+		if sampled == sample {
+			break
+		}
+		sampled++
+		if isExpired(item.expiresAt, now) {
+			delete(c.data, key)
+			if c.policy != nil {
+				c.policy.OnDelete(key)
+			}
+			expired++
+		}
+	}
+	return sampled, expired
+}
+
+// evictIfFull makes room for one new entry. The caller must hold the write lock.
+func (c *TypedRWMutexCache[K, V]) evictIfFull() {
+	for c.capacity > 0 && len(c.data) >= c.capacity {
+		victim, ok := c.policy.Evict()
+		if !ok {
+			return
+		}
+		delete(c.data, victim)
+	}
+}
@@ -3,25 +3,52 @@
 package inmemory
 
 import (
-	"hash/fnv"
+	"io"
 	"time"
+
+	"cachefy/serialization"
 )
 
 // ShardedCache is a thread-safe in-memory cache with multiple shards for scalability.
-type ShardedCache struct {
-	shards        []*RWMutexCache
+type ShardedCache = TypedShardedCache[string, interface{}]
+
+// TypedShardedCache is the generic form of ShardedCache for keys of type K and values of type V.
+type TypedShardedCache[K comparable, V any] struct {
+	shards        []*TypedRWMutexCache[K, V]
 	shardCount    int
 	defaultTTL    time.Duration
 	shardCapacity int
+	janitor       expiryJanitor
 }
 
 // NewShardedCache initializes a ShardedCache with the given number of shards and default TTL.
+// Each shard holds at most shardCapacity entries and evicts the least recently used one when full.
 func NewShardedCache(shardCount int, defaultTTL time.Duration, shardCapacity int) *ShardedCache {
-	shards := make([]*RWMutexCache, shardCount)
+	return NewTypedShardedCache[string, interface{}](shardCount, defaultTTL, shardCapacity)
+}
+
+// NewShardedCacheWithPolicy initializes a ShardedCache whose shards each enforce
+// shardCapacity using a policy created by newPolicy.
+func NewShardedCacheWithPolicy(shardCount int, defaultTTL time.Duration, shardCapacity int, newPolicy EvictionPolicyFactory) *ShardedCache {
+	return NewTypedShardedCacheWithPolicy[string, interface{}](shardCount, defaultTTL, shardCapacity, newPolicy)
+}
+
+// NewTypedShardedCache initializes a TypedShardedCache whose shards each evict
+// the least recently used entry when they reach shardCapacity.
+func NewTypedShardedCache[K comparable, V any](shardCount int, defaultTTL time.Duration, shardCapacity int) *TypedShardedCache[K, V] {
+	return NewTypedShardedCacheWithPolicy[K, V](shardCount, defaultTTL, shardCapacity, func() TypedEvictionPolicy[K] {
+		return NewTypedLRUPolicy[K]()
+	})
+}
+
+// NewTypedShardedCacheWithPolicy initializes a TypedShardedCache whose shards
+// each enforce shardCapacity using a policy created by newPolicy.
+func NewTypedShardedCacheWithPolicy[K comparable, V any](shardCount int, defaultTTL time.Duration, shardCapacity int, newPolicy TypedEvictionPolicyFactory[K]) *TypedShardedCache[K, V] {
+	shards := make([]*TypedRWMutexCache[K, V], shardCount)
 	for i := 0; i < shardCount; i++ {
-		shards[i] = NewRWMutexCache(defaultTTL)
+		shards[i] = NewBoundedTypedRWMutexCache[K, V](defaultTTL, shardCapacity, newPolicy())
 	}
-	return &ShardedCache{
+	return &TypedShardedCache[K, V]{
 		shards:        shards,
 		shardCount:    shardCount,
 		defaultTTL:    defaultTTL,
@@ -29,35 +56,114 @@ func NewShardedCache(shardCount int, defaultTTL time.Duration, shardCapacity int
 	}
 }
 
-// hashKey determines the shard index for a given key.
-func (c *ShardedCache) hashKey(key string) int {
-	hasher := fnv.New32a()
-	hasher.Write([]byte(key))
-	return int(hasher.Sum32()) % c.shardCount
+// shardFor determines the shard for a given key.
+func (c *TypedShardedCache[K, V]) shardFor(key K) *TypedRWMutexCache[K, V] {
+	return c.shards[hashKey(key)%uint64(c.shardCount)]
 }
 
 // Get retrieves a value from the cache.
-func (c *ShardedCache) Get(key string) (interface{}, error) {
-	shard := c.shards[c.hashKey(key)]
-	return shard.Get(key)
+func (c *TypedShardedCache[K, V]) Get(key K) (V, error) {
+	return c.shardFor(key).Get(key)
 }
 
 // Set stores a value in the cache.
-func (c *ShardedCache) Set(key string, value interface{}) error {
-	shard := c.shards[c.hashKey(key)]
-	return shard.Set(key, value)
+func (c *TypedShardedCache[K, V]) Set(key K, value V) error {
+	return c.shardFor(key).Set(key, value)
+}
+
+// SetWithTTL stores a value in the cache for ttl. A ttl <= 0 stores it without expiry.
+func (c *TypedShardedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) error {
+	return c.shardFor(key).SetWithTTL(key, value, ttl)
+}
+
+// TTL returns the remaining lifetime of key.
+func (c *TypedShardedCache[K, V]) TTL(key K) (time.Duration, error) {
+	return c.shardFor(key).TTL(key)
+}
+
+// Touch resets the lifetime of an existing key to ttl.
+func (c *TypedShardedCache[K, V]) Touch(key K, ttl time.Duration) error {
+	return c.shardFor(key).Touch(key, ttl)
+}
+
+// Persist removes the expiry of an existing key.
+func (c *TypedShardedCache[K, V]) Persist(key K) error {
+	return c.shardFor(key).Persist(key)
 }
 
 // Delete removes a value from the cache.
-func (c *ShardedCache) Delete(key string) error {
-	shard := c.shards[c.hashKey(key)]
-	return shard.Delete(key)
+func (c *TypedShardedCache[K, V]) Delete(key K) error {
+	return c.shardFor(key).Delete(key)
 }
 
 // Clear removes all entries from the cache.
-func (c *ShardedCache) Clear() error {
+func (c *TypedShardedCache[K, V]) Clear() error {
 	for _, shard := range c.shards {
 		shard.Clear()
 	}
 	return nil
 }
+
+// Len returns the number of stored entries across all shards.
+func (c *TypedShardedCache[K, V]) Len() int {
+	total := 0
+	for _, shard := range c.shards {
+		total += shard.Len()
+	}
+	return total
+}
+
+// Capacity returns the maximum number of entries across all shards.
+func (c *TypedShardedCache[K, V]) Capacity() int {
+	return c.shardCount * c.shardCapacity
+}
+
+// HasCapacityFor reports whether key can be stored without evicting another
+// entry from its shard.
+func (c *TypedShardedCache[K, V]) HasCapacityFor(key K) bool {
+	return c.shardFor(key).HasCapacityFor(key)
+}
+
+// Snapshot writes the live entries and their expiry times to w, encoded with
+// serializer; a nil serializer selects gob. All shards are captured at the same
+// instant. It returns the number of entries written.
+func (c *TypedShardedCache[K, V]) Snapshot(w io.Writer, serializer serialization.Serializer) (int, error) {
+	for _, shard := range c.shards {
+		shard.mutex.RLock()
+	}
+	now := time.Now()
+	var items []snapshotItem[K, V]
+	for _, shard := range c.shards {
+		items = shard.appendSnapshotItems(items, now)
+	}
+	for _, shard := range c.shards {
+		shard.mutex.RUnlock()
+	}
+
+	return writeSnapshot(w, serializer, items)
+}
+
+// Restore adds the unexpired entries of a snapshot written by Snapshot with the
+// same serializer, replacing existing entries with the same keys. A snapshot
+// holding more entries than a shard can hold evicts as Set would. Nothing is
+// restored from a snapshot that is truncated or corrupt. It returns the number
+// of entries restored.
+func (c *TypedShardedCache[K, V]) Restore(r io.Reader, serializer serialization.Serializer) (int, error) {
+	return readSnapshot(r, serializer, c.SetWithTTL)
+}
+
+// StartJanitor starts a background goroutine that removes expired entries
+// every interval, sweeping one shard at a time. It runs until Close is called.
+func (c *TypedShardedCache[K, V]) StartJanitor(interval time.Duration) {
+	c.janitor.start(interval, func() {
+		for _, shard := range c.shards {
+			activeExpire(shard.sweepExpired)
+		}
+	})
+}
+
+// Close stops the background janitor, if one is running.
+func (c *TypedShardedCache[K, V]) Close() error {
+	c.janitor.halt()
+	return nil
+}
@@ -0,0 +1,307 @@
+// File: snapshot.go
+
+package inmemory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"time"
+
+	"cachefy/serialization"
+)
+
+// ErrInvalidSnapshot indicates that a snapshot is truncated, corrupt or was
+// written with a different serializer.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshot layout:
+//
+//	header: magic "CFYS" | version byte | serializer ID byte
+//	entry:  1 | key length uvarint | key | tag length uvarint | tag |
+//	        value length uvarint | value | expires_at varint
+//	end:    0 | crc32 (IEEE) of everything before it, big-endian uint32
+//
+// Keys and values are encoded with the snapshot's serializer. Values whose
+// types are registered with serialization.Register are encoded as their
+// concrete type and tagged with its name; other values have an empty tag.
+// expires_at is in Unix nanoseconds, or zero for entries that never expire.
+var snapshotMagic = []byte("CFYS")
+
+const (
+	snapshotVersion byte = 1
+
+	snapshotEntryMarker byte = 1
+	snapshotEndMarker   byte = 0
+
+	// snapshotMaxFieldSize bounds a single key or value, so that a corrupt
+	// length cannot trigger a huge allocation.
+	snapshotMaxFieldSize = 1 << 30
+)
+
+// snapshotItem is a live entry captured for a snapshot.
+type snapshotItem[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// snapshotWriter writes the snapshot format, checksumming everything written.
+type snapshotWriter struct {
+	writer   *bufio.Writer
+	checksum hash.Hash32
+	scratch  [binary.MaxVarintLen64]byte
+}
+
+func (w *snapshotWriter) Write(p []byte) (int, error) {
+	w.checksum.Write(p)
+	return w.writer.Write(p)
+}
+
+func (w *snapshotWriter) writeBytes(data []byte) error {
+	n := binary.PutUvarint(w.scratch[:], uint64(len(data)))
+	if _, err := w.Write(w.scratch[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeSnapshot writes items to w, encoding them with serializer; a nil
+// serializer selects serialization.BlobSerializer. It returns the number of
+// entries written.
+func writeSnapshot[K comparable, V any](w io.Writer, serializer serialization.Serializer, items []snapshotItem[K, V]) (int, error) {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
+	sw := &snapshotWriter{writer: bufio.NewWriter(w), checksum: crc32.NewIEEE()}
+
+	header := append(append([]byte{}, snapshotMagic...), snapshotVersion, serialization.SerializerID(serializer))
+	if _, err := sw.Write(header); err != nil {
+		return 0, err
+	}
+
+	for i, item := range items {
+		key, err := serializer.Marshal(item.key)
+		if err != nil {
+			return i, err
+		}
+		tag, value, err := marshalSnapshotValue(serializer, item.value)
+		if err != nil {
+			return i, fmt.Errorf("encoding value of %v: %w", item.key, err)
+		}
+		var expiresAt int64
+		if !item.expiresAt.IsZero() {
+			expiresAt = item.expiresAt.UnixNano()
+		}
+
+		if _, err := sw.Write([]byte{snapshotEntryMarker}); err != nil {
+			return i, err
+		}
+		if err := sw.writeBytes(key); err != nil {
+			return i, err
+		}
+		if err := sw.writeBytes([]byte(tag)); err != nil {
+			return i, err
+		}
+		if err := sw.writeBytes(value); err != nil {
+			return i, err
+		}
+		n := binary.PutVarint(sw.scratch[:], expiresAt)
+		if _, err := sw.Write(sw.scratch[:n]); err != nil {
+			return i, err
+		}
+	}
+
+	if _, err := sw.Write([]byte{snapshotEndMarker}); err != nil {
+		return len(items), err
+	}
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], sw.checksum.Sum32())
+	if _, err := sw.writer.Write(trailer[:]); err != nil {
+		return len(items), err
+	}
+	return len(items), sw.writer.Flush()
+}
+
+// marshalSnapshotValue encodes value, tagged with the name its type is
+// registered under in serialization.DefaultRegistry, or "" if it has none.
+func marshalSnapshotValue[V any](serializer serialization.Serializer, value V) (string, []byte, error) {
+	if tag, ok := serialization.DefaultRegistry.Name(value); ok {
+		data, err := serializer.Marshal(value)
+		return tag, data, err
+	}
+	// Marshal through a pointer so that type-aware serializers, such as gob,
+	// record the concrete type of interface values.
+	data, err := serializer.Marshal(&value)
+	return "", data, err
+}
+
+// unmarshalSnapshotValue restores a value encoded by marshalSnapshotValue.
+func unmarshalSnapshotValue[V any](serializer serialization.Serializer, tag string, data []byte) (V, error) {
+	var value V
+	if tag != "" {
+		if ptr, ok := serialization.DefaultRegistry.New(tag); ok {
+			if err := serializer.Unmarshal(data, ptr); err != nil {
+				return value, err
+			}
+			restored, ok := reflect.ValueOf(ptr).Elem().Interface().(V)
+			if !ok {
+				return value, fmt.Errorf("%w: value of type %s does not fit the cache", ErrInvalidSnapshot, tag)
+			}
+			return restored, nil
+		}
+	}
+	err := serializer.Unmarshal(data, &value)
+	return value, err
+}
+
+// snapshotReader reads the snapshot format, checksumming everything read.
+type snapshotReader struct {
+	reader   *bufio.Reader
+	checksum hash.Hash32
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.checksum.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(r.reader, p); err != nil {
+		return err
+	}
+	r.checksum.Write(p)
+	return nil
+}
+
+func (r *snapshotReader) readBytes() ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > snapshotMaxFieldSize {
+		return nil, fmt.Errorf("%w: field of %d bytes", ErrInvalidSnapshot, length)
+	}
+	data := make([]byte, length)
+	return data, r.readFull(data)
+}
+
+// readSnapshot reads a whole snapshot from rd and verifies it, then passes
+// each unexpired entry to restore with its remaining lifetime, where zero
+// means no expiry. Nothing is restored from a snapshot that fails to verify.
+// It returns the number of entries restored.
+func readSnapshot[K comparable, V any](rd io.Reader, serializer serialization.Serializer, restore func(key K, value V, ttl time.Duration) error) (int, error) {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
+	sr := &snapshotReader{reader: bufio.NewReader(rd), checksum: crc32.NewIEEE()}
+
+	header := make([]byte, len(snapshotMagic)+2)
+	if err := sr.readFull(header); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if !bytes.HasPrefix(header, snapshotMagic) {
+		return 0, fmt.Errorf("%w: missing header", ErrInvalidSnapshot)
+	}
+	if header[4] != snapshotVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, header[4])
+	}
+	if id := serialization.SerializerID(serializer); header[5] != id {
+		return 0, fmt.Errorf("%w: written with serializer %d, reading with %d", ErrInvalidSnapshot, header[5], id)
+	}
+
+	var items []snapshotItem[K, V]
+	for {
+		marker, err := sr.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrInvalidSnapshot, unexpectedEOF(err))
+		}
+		if marker == snapshotEndMarker {
+			break
+		} else if marker != snapshotEntryMarker {
+			return 0, fmt.Errorf("%w: unknown marker %d", ErrInvalidSnapshot, marker)
+		}
+
+		item, err := readSnapshotItem[K, V](sr, serializer)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrInvalidSnapshot, unexpectedEOF(err))
+		}
+		items = append(items, item)
+	}
+
+	expected := sr.checksum.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(sr.reader, trailer[:]); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSnapshot, unexpectedEOF(err))
+	}
+	if binary.BigEndian.Uint32(trailer[:]) != expected {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	now := time.Now()
+	restored := 0
+	for _, item := range items {
+		var ttl time.Duration
+		if !item.expiresAt.IsZero() {
+			ttl = item.expiresAt.Sub(now)
+			if ttl <= 0 {
+				continue
+			}
+		}
+		if err := restore(item.key, item.value, ttl); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// readSnapshotItem reads the fields of one entry, after its marker.
+func readSnapshotItem[K comparable, V any](sr *snapshotReader, serializer serialization.Serializer) (snapshotItem[K, V], error) {
+	var item snapshotItem[K, V]
+	keyData, err := sr.readBytes()
+	if err != nil {
+		return item, err
+	}
+	tag, err := sr.readBytes()
+	if err != nil {
+		return item, err
+	}
+	valueData, err := sr.readBytes()
+	if err != nil {
+		return item, err
+	}
+	expiresAt, err := binary.ReadVarint(sr)
+	if err != nil {
+		return item, err
+	}
+
+	if err := serializer.Unmarshal(keyData, &item.key); err != nil {
+		return item, err
+	}
+	if item.value, err = unmarshalSnapshotValue[V](serializer, string(tag), valueData); err != nil {
+		return item, err
+	}
+	if expiresAt != 0 {
+		item.expiresAt = time.Unix(0, expiresAt)
+	}
+	return item, nil
+}
+
+// unexpectedEOF reports a snapshot that ends early as io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
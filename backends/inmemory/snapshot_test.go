@@ -0,0 +1,87 @@
+// File: snapshot_test.go
+
+package inmemory
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"cachefy/serialization"
+)
+
+// snapshotCache is implemented by the backends that support snapshots.
+type snapshotCache interface {
+	SetWithTTL(key string, value interface{}, ttl time.Duration) error
+	Get(key string) (interface{}, error)
+	TTL(key string) (time.Duration, error)
+	Snapshot(w io.Writer, serializer serialization.Serializer) (int, error)
+	Restore(r io.Reader, serializer serialization.Serializer) (int, error)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	newCaches := map[string]func() snapshotCache{
+		"rwmutex": func() snapshotCache { return NewRWMutexCache(time.Minute) },
+		"syncmap": func() snapshotCache { return NewSyncMapCache(time.Minute) },
+		"sharded": func() snapshotCache { return NewShardedCache(4, time.Minute, 100) },
+	}
+	serializers := map[string]serialization.Serializer{
+		"gob":  &serialization.BlobSerializer{},
+		"json": &serialization.JSONSerializer{},
+	}
+
+	for cacheName, newCache := range newCaches {
+		for serializerName, serializer := range serializers {
+			t.Run(cacheName+"/"+serializerName, func(t *testing.T) {
+				testSnapshotRestore(t, newCache, serializer)
+			})
+		}
+	}
+}
+
+func testSnapshotRestore(t *testing.T, newCache func() snapshotCache, serializer serialization.Serializer) {
+	source := newCache()
+	source.SetWithTTL("string", "value", time.Hour)
+	source.SetWithTTL("int", 42, 0)
+	source.SetWithTTL("expiring", "soon", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	var buf bytes.Buffer
+	written, err := source.Snapshot(&buf, serializer)
+	if err != nil || written != 2 {
+		t.Fatalf("Expected 2 entries written, got %d, error: %v", written, err)
+	}
+
+	// Test that a truncated snapshot restores nothing
+	target := newCache()
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if _, err := target.Restore(bytes.NewReader(truncated), serializer); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot for a truncated snapshot, got %v", err)
+	}
+	if _, err := target.Get("string"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Expected nothing restored from a truncated snapshot, got %v", err)
+	}
+
+	// Test that live entries are restored with their types and TTLs
+	restored, err := target.Restore(bytes.NewReader(buf.Bytes()), serializer)
+	if err != nil || restored != 2 {
+		t.Fatalf("Expected 2 entries restored, got %d, error: %v", restored, err)
+	}
+	if value, err := target.Get("string"); err != nil || value != "value" {
+		t.Errorf("Expected 'value', got %v, error: %v", value, err)
+	}
+	if value, err := target.Get("int"); err != nil || value != 42 {
+		t.Errorf("Expected int 42, got %#v, error: %v", value, err)
+	}
+	if ttl, err := target.TTL("string"); err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected a TTL of about an hour, got %v, error: %v", ttl, err)
+	}
+	if ttl, err := target.TTL("int"); err != nil || ttl >= 0 {
+		t.Errorf("Expected no expiry for int, got %v, error: %v", ttl, err)
+	}
+	if _, err := target.Get("expiring"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Expected expired entry to be left out, got %v", err)
+	}
+}
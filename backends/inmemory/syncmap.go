@@ -3,57 +3,169 @@
 package inmemory
 
 import (
+	"io"
 	"sync"
 	"time"
+
+	"cachefy/serialization"
 )
 
-type SyncMapCache struct {
+type SyncMapCache = TypedSyncMapCache[string, interface{}]
+
+// TypedSyncMapCache is the generic form of SyncMapCache for keys of type K and values of type V.
+type TypedSyncMapCache[K comparable, V any] struct {
 	data       sync.Map
 	defaultTTL time.Duration
+	janitor    expiryJanitor
 }
 
-type syncMapItem struct {
-	value     interface{}
+type syncMapItem[V any] struct {
+	value     V
 	expiresAt time.Time
 }
 
 func NewSyncMapCache(defaultTTL time.Duration) *SyncMapCache {
-	return &SyncMapCache{
+	return NewTypedSyncMapCache[string, interface{}](defaultTTL)
+}
+
+// NewTypedSyncMapCache creates a new TypedSyncMapCache instance with the provided default TTL.
+func NewTypedSyncMapCache[K comparable, V any](defaultTTL time.Duration) *TypedSyncMapCache[K, V] {
+	return &TypedSyncMapCache[K, V]{
 		defaultTTL: defaultTTL,
 	}
 }
 
-func (c *SyncMapCache) Get(key string) (interface{}, error) {
+func (c *TypedSyncMapCache[K, V]) Get(key K) (V, error) {
+	var zero V
 	item, ok := c.data.Load(key)
 	if !ok {
-		return nil, ErrCacheMiss
+		return zero, ErrCacheMiss
 	}
 
-	cachedItem := item.(syncMapItem)
-	if time.Now().After(cachedItem.expiresAt) {
-		c.data.Delete(key)
-		return nil, ErrCacheMiss
+	cachedItem := item.(*syncMapItem[V])
+	if isExpired(cachedItem.expiresAt, time.Now()) {
+		c.data.CompareAndDelete(key, item)
+		return zero, ErrCacheMiss
 	}
 	return cachedItem.value, nil
 }
 
-func (c *SyncMapCache) Set(key string, value interface{}) error {
-	c.data.Store(key, syncMapItem{
+func (c *TypedSyncMapCache[K, V]) Set(key K, value V) error {
+	return c.SetWithTTL(key, value, c.defaultTTL)
+}
+
+// SetWithTTL stores value under key for ttl. A ttl <= 0 stores it without expiry.
+func (c *TypedSyncMapCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) error {
+	c.data.Store(key, &syncMapItem[V]{
 		value:     value,
-		expiresAt: time.Now().Add(c.defaultTTL),
+		expiresAt: expiryFor(ttl),
 	})
 	return nil
 }
 
-func (c *SyncMapCache) Delete(key string) error {
+// TTL returns the remaining lifetime of key, or interfaces.NoExpiration if it never expires.
+func (c *TypedSyncMapCache[K, V]) TTL(key K) (time.Duration, error) {
+	item, ok := c.data.Load(key)
+	if !ok {
+		return 0, ErrCacheMiss
+	}
+
+	now := time.Now()
+	cachedItem := item.(*syncMapItem[V])
+	if isExpired(cachedItem.expiresAt, now) {
+		return 0, ErrCacheMiss
+	}
+	return remainingTTL(cachedItem.expiresAt, now), nil
+}
+
+// Touch resets the lifetime of an existing key to ttl. A ttl <= 0 removes its expiry.
+func (c *TypedSyncMapCache[K, V]) Touch(key K, ttl time.Duration) error {
+	for {
+		item, ok := c.data.Load(key)
+		if !ok {
+			return ErrCacheMiss
+		}
+
+		cachedItem := item.(*syncMapItem[V])
+		if isExpired(cachedItem.expiresAt, time.Now()) {
+			return ErrCacheMiss
+		}
+		touched := &syncMapItem[V]{value: cachedItem.value, expiresAt: expiryFor(ttl)}
+		if c.data.CompareAndSwap(key, item, touched) {
+			return nil
+		}
+		// Lost a race with a concurrent write; retry against the new item.
+	}
+}
+
+// Persist removes the expiry of an existing key.
+func (c *TypedSyncMapCache[K, V]) Persist(key K) error {
+	return c.Touch(key, 0)
+}
+
+func (c *TypedSyncMapCache[K, V]) Delete(key K) error {
 	c.data.Delete(key)
 	return nil
 }
 
-func (c *SyncMapCache) Clear() error {
+func (c *TypedSyncMapCache[K, V]) Clear() error {
 	c.data.Range(func(key, value interface{}) bool {
 		c.data.Delete(key)
 		return true
 	})
 	return nil
 }
+
+// Snapshot writes the live entries and their expiry times to w, encoded with
+// serializer; a nil serializer selects gob. Writes made while the snapshot is
+// taken may or may not be included. It returns the number of entries written.
+func (c *TypedSyncMapCache[K, V]) Snapshot(w io.Writer, serializer serialization.Serializer) (int, error) {
+	now := time.Now()
+	var items []snapshotItem[K, V]
+	c.data.Range(func(key, value interface{}) bool {
+		item := value.(*syncMapItem[V])
+		if !isExpired(item.expiresAt, now) {
+			items = append(items, snapshotItem[K, V]{key: key.(K), value: item.value, expiresAt: item.expiresAt})
+		}
+		return true
+	})
+
+	return writeSnapshot(w, serializer, items)
+}
+
+// Restore adds the unexpired entries of a snapshot written by Snapshot with the
+// same serializer, replacing existing entries with the same keys. Entries keep
+// the expiry times they had when the snapshot was taken. Nothing is restored
+// from a snapshot that is truncated or corrupt. It returns the number of
+// entries restored.
+func (c *TypedSyncMapCache[K, V]) Restore(r io.Reader, serializer serialization.Serializer) (int, error) {
+	return readSnapshot(r, serializer, c.SetWithTTL)
+}
+
+// StartJanitor starts a background goroutine that removes expired entries
+// every interval. It runs until Close is called.
+func (c *TypedSyncMapCache[K, V]) StartJanitor(interval time.Duration) {
+	c.janitor.start(interval, func() { activeExpire(c.sweepExpired) })
+}
+
+// Close stops the background janitor, if one is running.
+func (c *TypedSyncMapCache[K, V]) Close() error {
+	c.janitor.halt()
+	return nil
+}
+
+// sweepExpired removes expired entries among a sample of up to sample entries.
+func (c *TypedSyncMapCache[K, V]) sweepExpired(sample int) (sampled, expired int) {
+	now := time.Now()
+	c.data.Range(func(key, value interface{}) bool {
+		sampled++
+		if isExpired(value.(*syncMapItem[V]).expiresAt, now) {
+			// Only delete the item we inspected, not a concurrent replacement.
+			if c.data.CompareAndDelete(key, value) {
+				expired++
+			}
+		}
+		return sampled < sample
+	})
+	return sampled, expired
+}
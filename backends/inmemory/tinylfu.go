@@ -0,0 +1,359 @@
+// File: tinylfu.go
+
+package inmemory
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TinyLFUCache is a bounded in-memory cache using the W-TinyLFU policy: new
+// entries enter a small LRU window, and candidates leaving the window are only
+// admitted into the segmented main LRU if a count-min sketch estimates that they
+// are accessed more often than the entry they would displace. This keeps hot
+// keys resident under scan-heavy traffic.
+type TinyLFUCache = TypedTinyLFUCache[string, interface{}]
+
+// TypedTinyLFUCache is the generic form of TinyLFUCache for keys of type K and values of type V.
+type TypedTinyLFUCache[K comparable, V any] struct {
+	data       map[K]*list.Element
+	window     *list.List
+	probation  *list.List
+	protected  *list.List
+	sketch     *countMinSketch
+	mutex      sync.Mutex
+	defaultTTL time.Duration
+	janitor    expiryJanitor
+
+	capacity     int
+	windowCap    int
+	protectedCap int
+}
+
+type tinyLFUSegment int
+
+const (
+	segmentWindow tinyLFUSegment = iota
+	segmentProbation
+	segmentProtected
+)
+
+type tinyLFUEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+	segment   tinyLFUSegment
+}
+
+// NewTinyLFUCache creates a TinyLFUCache holding at most capacity entries.
+// One percent of the capacity is used for the admission window, and eighty
+// percent of the remainder for the protected segment of the main LRU.
+func NewTinyLFUCache(defaultTTL time.Duration, capacity int) *TinyLFUCache {
+	return NewTypedTinyLFUCache[string, interface{}](defaultTTL, capacity)
+}
+
+// NewTypedTinyLFUCache creates a TypedTinyLFUCache holding at most capacity entries.
+func NewTypedTinyLFUCache[K comparable, V any](defaultTTL time.Duration, capacity int) *TypedTinyLFUCache[K, V] {
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	protectedCap := (capacity - windowCap) * 8 / 10
+	return &TypedTinyLFUCache[K, V]{
+		data:         make(map[K]*list.Element, capacity),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		sketch:       newCountMinSketch(capacity),
+		defaultTTL:   defaultTTL,
+		capacity:     capacity,
+		windowCap:    windowCap,
+		protectedCap: protectedCap,
+	}
+}
+
+// Get retrieves the value associated with the given key.
+func (c *TypedTinyLFUCache[K, V]) Get(key K) (V, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sketch.Increment(hashKey(key))
+	var zero V
+	elem, exists := c.data[key]
+	if !exists {
+		return zero, ErrCacheMiss
+	}
+	entry := elem.Value.(*tinyLFUEntry[K, V])
+	if isExpired(entry.expiresAt, time.Now()) {
+		c.remove(elem)
+		return zero, ErrCacheMiss
+	}
+	c.touch(elem)
+	return entry.value, nil
+}
+
+// Set stores the value associated with the given key.
+func (c *TypedTinyLFUCache[K, V]) Set(key K, value V) error {
+	return c.SetWithTTL(key, value, c.defaultTTL)
+}
+
+// SetWithTTL stores the value associated with the given key for ttl.
+// A ttl <= 0 stores the value without expiry.
+func (c *TypedTinyLFUCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sketch.Increment(hashKey(key))
+	expiresAt := expiryFor(ttl)
+	if elem, exists := c.data[key]; exists {
+		entry := elem.Value.(*tinyLFUEntry[K, V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.touch(elem)
+		return nil
+	}
+
+	entry := &tinyLFUEntry[K, V]{key: key, value: value, expiresAt: expiresAt, segment: segmentWindow}
+	c.data[key] = c.window.PushFront(entry)
+	if c.window.Len() > c.windowCap {
+		c.admit(c.window.Back())
+	}
+	return nil
+}
+
+// TTL returns the remaining lifetime of key, or interfaces.NoExpiration if it never expires.
+func (c *TypedTinyLFUCache[K, V]) TTL(key K) (time.Duration, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	elem, exists := c.data[key]
+	if !exists || isExpired(elem.Value.(*tinyLFUEntry[K, V]).expiresAt, now) {
+		return 0, ErrCacheMiss
+	}
+	return remainingTTL(elem.Value.(*tinyLFUEntry[K, V]).expiresAt, now), nil
+}
+
+// Touch resets the lifetime of an existing key to ttl. A ttl <= 0 removes its expiry.
+func (c *TypedTinyLFUCache[K, V]) Touch(key K, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, exists := c.data[key]
+	if !exists || isExpired(elem.Value.(*tinyLFUEntry[K, V]).expiresAt, time.Now()) {
+		return ErrCacheMiss
+	}
+	elem.Value.(*tinyLFUEntry[K, V]).expiresAt = expiryFor(ttl)
+	return nil
+}
+
+// Persist removes the expiry of an existing key.
+func (c *TypedTinyLFUCache[K, V]) Persist(key K) error {
+	return c.Touch(key, 0)
+}
+
+// Delete removes the value associated with the given key.
+func (c *TypedTinyLFUCache[K, V]) Delete(key K) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, exists := c.data[key]; exists {
+		c.remove(elem)
+	}
+	return nil
+}
+
+// Clear removes all entries from the cache. Frequency history is kept.
+func (c *TypedTinyLFUCache[K, V]) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.data = make(map[K]*list.Element, c.capacity)
+	c.window.Init()
+	c.probation.Init()
+	c.protected.Init()
+	return nil
+}
+
+// Len returns the number of stored entries.
+func (c *TypedTinyLFUCache[K, V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.data)
+}
+
+// Capacity returns the maximum number of entries.
+func (c *TypedTinyLFUCache[K, V]) Capacity() int {
+	return c.capacity
+}
+
+// HasCapacityFor reports whether key can be stored without evicting another entry.
+func (c *TypedTinyLFUCache[K, V]) HasCapacityFor(key K) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, exists := c.data[key]
+	return exists || len(c.data) < c.capacity
+}
+
+// StartJanitor starts a background goroutine that removes expired entries
+// every interval. It runs until Close is called.
+func (c *TypedTinyLFUCache[K, V]) StartJanitor(interval time.Duration) {
+	c.janitor.start(interval, func() { activeExpire(c.sweepExpired) })
+}
+
+// Close stops the background janitor, if one is running.
+func (c *TypedTinyLFUCache[K, V]) Close() error {
+	c.janitor.halt()
+	return nil
+}
+
+// sweepExpired removes expired entries among a sample of up to sample entries.
+func (c *TypedTinyLFUCache[K, V]) sweepExpired(sample int) (sampled, expired int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for _, elem := range c.data {
+		if sampled == sample {
+			break
+		}
+		sampled++
+		if isExpired(elem.Value.(*tinyLFUEntry[K, V]).expiresAt, now) {
+			c.remove(elem)
+			expired++
+		}
+	}
+	return sampled, expired
+}
+
+// touch records a hit on elem, promoting probation entries to the protected segment.
+func (c *TypedTinyLFUCache[K, V]) touch(elem *list.Element) {
+	entry := elem.Value.(*tinyLFUEntry[K, V])
+	switch entry.segment {
+	case segmentWindow:
+		c.window.MoveToFront(elem)
+	case segmentProtected:
+		c.protected.MoveToFront(elem)
+	case segmentProbation:
+		c.probation.Remove(elem)
+		entry.segment = segmentProtected
+		c.data[entry.key] = c.protected.PushFront(entry)
+		if c.protected.Len() > c.protectedCap {
+			demoted := c.protected.Remove(c.protected.Back()).(*tinyLFUEntry[K, V])
+			demoted.segment = segmentProbation
+			c.data[demoted.key] = c.probation.PushFront(demoted)
+		}
+	}
+}
+
+// admit moves the window's LRU entry into the main segments, or drops it if the
+// main segments are full and it is not estimated to be hotter than their victim.
+func (c *TypedTinyLFUCache[K, V]) admit(candidateElem *list.Element) {
+	candidate := c.window.Remove(candidateElem).(*tinyLFUEntry[K, V])
+	candidate.segment = segmentProbation
+
+	if c.probation.Len()+c.protected.Len() >= c.capacity-c.windowCap {
+		victimElem := c.probation.Back()
+		if victimElem == nil {
+			victimElem = c.protected.Back()
+		}
+		if victimElem == nil {
+			// The main segments have no room at all (capacity of one).
+			delete(c.data, candidate.key)
+			return
+		}
+		victim := victimElem.Value.(*tinyLFUEntry[K, V])
+		if c.sketch.Estimate(hashKey(candidate.key)) <= c.sketch.Estimate(hashKey(victim.key)) {
+			delete(c.data, candidate.key)
+			return
+		}
+		c.remove(victimElem)
+	}
+	c.data[candidate.key] = c.probation.PushFront(candidate)
+}
+
+func (c *TypedTinyLFUCache[K, V]) remove(elem *list.Element) {
+	entry := elem.Value.(*tinyLFUEntry[K, V])
+	switch entry.segment {
+	case segmentWindow:
+		c.window.Remove(elem)
+	case segmentProbation:
+		c.probation.Remove(elem)
+	case segmentProtected:
+		c.protected.Remove(elem)
+	}
+	delete(c.data, entry.key)
+}
+
+// countMinSketch estimates key access frequencies using four rows of
+// saturating 4-bit counters. All counters are halved once the number of
+// increments reaches ten times the cache capacity so that old popularity fades.
+type countMinSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+const sketchMaxCount = 15
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:    uint64(width - 1),
+		resetAt: 10 * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// indexes derives one counter index per row from a single 64-bit key hash.
+func (s *countMinSketch) indexes(h uint64) [4]uint64 {
+	h1, h2 := h&0xffffffff, h>>32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+// Increment counts one access of the key with hash h.
+func (s *countMinSketch) Increment(h uint64) {
+	for i, j := range s.indexes(h) {
+		if s.rows[i][j] < sketchMaxCount {
+			s.rows[i][j]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// Estimate returns the approximate access count of the key with hash h.
+func (s *countMinSketch) Estimate(h uint64) uint8 {
+	estimate := uint8(sketchMaxCount)
+	for i, j := range s.indexes(h) {
+		if s.rows[i][j] < estimate {
+			estimate = s.rows[i][j]
+		}
+	}
+	return estimate
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
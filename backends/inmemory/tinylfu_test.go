@@ -0,0 +1,67 @@
+// File: tinylfu_test.go
+
+package inmemory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTinyLFUCache(t *testing.T) {
+	cache := NewTinyLFUCache(2*time.Second, 100)
+
+	// Test Set and Get
+	cache.Set("key1", "value1")
+	val, err := cache.Get("key1")
+	if err != nil || val != "value1" {
+		t.Errorf("Expected value1, got %v, error: %v", val, err)
+	}
+
+	// Test expiration
+	time.Sleep(3 * time.Second)
+	val, err = cache.Get("key1")
+	if err == nil {
+		t.Errorf("Expected cache miss, got value: %v", val)
+	}
+
+	// Test Clear
+	cache.Set("key2", "value2")
+	cache.Clear()
+	_, err = cache.Get("key2")
+	if err == nil {
+		t.Errorf("Expected cache miss after Clear")
+	}
+}
+
+func TestTinyLFUCacheScanResistance(t *testing.T) {
+	cache := NewTinyLFUCache(time.Minute, 100)
+
+	// Build up frequency for a hot set that fits in the cache.
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("hot%d", i)
+			if _, err := cache.Get(key); err != nil {
+				cache.Set(key, i)
+			}
+		}
+	}
+
+	// A one-off scan over many cold keys should not flush the hot set.
+	for i := 0; i < 10000; i++ {
+		cache.Set(fmt.Sprintf("scan%d", i), i)
+	}
+
+	hits := 0
+	for i := 0; i < 50; i++ {
+		if _, err := cache.Get(fmt.Sprintf("hot%d", i)); err == nil {
+			hits++
+		}
+	}
+	if hits < 45 {
+		t.Errorf("Expected hot keys to survive the scan, got %d/50 hits", hits)
+	}
+	if cache.Len() > 100 {
+		t.Errorf("Expected at most 100 entries, got %d", cache.Len())
+	}
+}
@@ -0,0 +1,31 @@
+// File: ttl.go
+
+package inmemory
+
+import (
+	"time"
+
+	"cachefy/interfaces"
+)
+
+// expiryFor returns the absolute expiry for ttl; the zero time means no expiry.
+func expiryFor(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// isExpired reports whether an entry with the given expiry is expired at now.
+func isExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && now.After(expiresAt)
+}
+
+// remainingTTL returns the lifetime left before expiresAt, or
+// interfaces.NoExpiration if the entry never expires.
+func remainingTTL(expiresAt, now time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return interfaces.NoExpiration
+	}
+	return expiresAt.Sub(now)
+}
@@ -0,0 +1,65 @@
+// File: ttl_test.go
+
+package inmemory
+
+import (
+	"testing"
+	"time"
+
+	"cachefy/interfaces"
+)
+
+func TestPerKeyTTL(t *testing.T) {
+	caches := map[string]interfaces.Cache{
+		"syncmap": NewSyncMapCache(time.Minute),
+		"rwmutex": NewRWMutexCache(time.Minute),
+		"sharded": NewShardedCache(4, time.Minute, 100),
+		"tinylfu": NewTinyLFUCache(time.Minute, 100),
+	}
+
+	for name, cache := range caches {
+		// Test SetWithTTL and TTL
+		cache.SetWithTTL("short", "value", 100*time.Millisecond)
+		cache.SetWithTTL("forever", "value", 0)
+		cache.Set("default", "value")
+
+		if ttl, err := cache.TTL("short"); err != nil || ttl <= 0 || ttl > 100*time.Millisecond {
+			t.Errorf("%s: expected short TTL, got %v, error: %v", name, ttl, err)
+		}
+		if ttl, err := cache.TTL("forever"); err != nil || ttl != interfaces.NoExpiration {
+			t.Errorf("%s: expected NoExpiration, got %v, error: %v", name, ttl, err)
+		}
+		if ttl, err := cache.TTL("default"); err != nil || ttl <= 59*time.Second {
+			t.Errorf("%s: expected default TTL, got %v, error: %v", name, ttl, err)
+		}
+
+		// Test Touch and Persist
+		cache.SetWithTTL("touched", "value", 100*time.Millisecond)
+		cache.SetWithTTL("persisted", "value", 100*time.Millisecond)
+		if err := cache.Touch("touched", time.Minute); err != nil {
+			t.Errorf("%s: failed to touch: %v", name, err)
+		}
+		if err := cache.Persist("persisted"); err != nil {
+			t.Errorf("%s: failed to persist: %v", name, err)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		if _, err := cache.Get("short"); err == nil {
+			t.Errorf("%s: expected short to expire", name)
+		}
+		for _, key := range []string{"forever", "touched", "persisted"} {
+			if _, err := cache.Get(key); err != nil {
+				t.Errorf("%s: expected %s to survive, error: %v", name, key, err)
+			}
+		}
+
+		// Test missing keys
+		if _, err := cache.TTL("short"); err != ErrCacheMiss {
+			t.Errorf("%s: expected ErrCacheMiss from TTL, got %v", name, err)
+		}
+		if err := cache.Touch("missing", time.Minute); err != ErrCacheMiss {
+			t.Errorf("%s: expected ErrCacheMiss from Touch, got %v", name, err)
+		}
+	}
+}
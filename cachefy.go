@@ -3,77 +3,311 @@
 package cachefy
 
 import (
-	"cachefy/backends/inmemory"
 	"cachefy/interfaces"
 	"cachefy/persistence"
 	"cachefy/repository"
+	"cachefy/serialization"
 	"errors"
+	"io"
 	"log"
 	"time"
 )
 
 type CacheConfig struct {
-	DefaultTTL               time.Duration
-	Backend                  string
-	Shards                   int
-	ShardCapacity            int
-	EnablePersistence        bool
-	PersistenceFilePath      string
-	PersistenceFlushInterval time.Duration
-	DatabaseType             string // "sqlite" or "postgres"
-	DatabaseDSN              string // Database connection string
+	DefaultTTL                time.Duration
+	Backend                   string
+	Shards                    int
+	ShardCapacity             int
+	EvictionPolicy            string        // "lru" (default), "lfu", "fifo" or "random"
+	Capacity                  int           // Maximum number of entries for the "tinylfu" backend
+	CleanupInterval           time.Duration // Interval between background expiry sweeps; zero disables the janitor
+	EnablePersistence         bool
+	PersistenceFilePath       string            // Log file of the "file" DatabaseType; without EnablePersistence, the snapshot file
+	PersistenceFlushInterval  time.Duration     // How often write-behind batches are flushed, zero flushing as writes arrive; without EnablePersistence, how often snapshots are written
+	PersistenceSnapshots      int               // Snapshot generations kept, including the latest; defaults to 3
+	StartWithoutSnapshot      bool              // Start empty, with snapshots suspended, if no snapshot can be restored, instead of failing
+	DatabaseType              string            // "sqlite", "postgres" or "file"
+	DatabaseDSN               string            // Database connection string
+	Serializer                string            // Encoding of persisted values: "gob", "json", "msgpack" or "cbor"; defaults to gob for SQLite and JSON for Postgres
+	Compression               string            // Codec for large persisted values: "" (none), "gzip", "flate" or a name passed to serialization.RegisterCodec
+	CompressionThreshold      int               // Smallest persisted value, in bytes, that is compressed; defaults to 1024
+	EncryptionKeys            map[string][]byte // AES-GCM keys of 16, 24 or 32 bytes by key ID; persisted values are encrypted when set
+	EncryptionKeyID           string            // ID of the key that encrypts new values; the other keys only decrypt older values
+	EncryptionReadPlaintext   bool              // Read unencrypted values stored before EncryptionKeys was set, unauthenticated, until they are rewritten; without it they fail with serialization.ErrNotEncrypted
+	DeleteCorruptValues       bool              // Delete persisted values that fail their integrity checks instead of returning an error
+	MigratePostgresValues     bool              // Convert a JSONB value column left by earlier versions to BYTEA on startup; irreversible
+	WarmUpOnStart             bool              // Load persisted entries into memory when the cache is created
+	WarmUpBatchSize           int               // Entries read per repository page during warm-up; defaults to 1000
+	WriteBehind               bool              // Persist writes and deletes asynchronously instead of on the caller's path
+	PersistenceQueueSize      int               // Write-behind queue capacity; defaults to 1024
+	PersistenceRetryLimit     int               // Write-behind attempts per batch; defaults to 3
+	PersistenceBatchSize      int               // Pending write-behind keys that trigger an early flush; defaults to 100
+	PersistenceWorkers        int               // Write-behind workers; keys are hashed to workers so each key's writes stay ordered; defaults to 1
+	PersistenceRetryDelay     time.Duration     // Delay before the first write-behind retry, doubling per attempt; defaults to 100ms
+	PersistenceRetryMaxDelay  time.Duration     // Upper bound on write-behind retry delays; defaults to 30s
+	PersistenceBackpressure   string            // Full write-behind queue: "block" (default), "drop-newest", "drop-oldest" or "spill"
+	PersistenceEnqueueTimeout time.Duration     // Longest a write waits for queue room under "block"; zero waits indefinitely
+	PersistenceOverflowPath   string            // Overflow file for the "spill" backpressure policy
+	PersistenceDeadLetter     string            // Where writes that exhaust their retries go: "" (logged only), "memory", "file" or "repository"
+	PersistenceDeadLetterPath string            // File for the "file" dead-letter store
+	PersistenceDeadLetterDSN  string            // Connection string, or file path, of a separate DatabaseType database for the "repository" dead-letter store; must differ from the cache database
+	PersistenceDeadLetterSize int               // Letters kept by the "memory" dead-letter store; defaults to 1000
 }
 
+const (
+	defaultWarmUpBatchSize       = 1000
+	defaultPersistenceQueueSize  = 1024
+	defaultPersistenceRetryLimit = 3
+)
+
 func NewCache(config CacheConfig) (interfaces.Cache, error) {
-	// Validate config
-	if config.DefaultTTL <= 0 {
-		return nil, errors.New("defaultTTL must be greater than zero")
+	backend, err := newBackend[string, interface{}](config)
+	if err != nil {
+		return nil, err
 	}
+	var cache interfaces.Cache = backend
 
-	var cache interfaces.Cache
-	switch config.Backend {
-	case "syncmap":
-		cache = inmemory.NewSyncMapCache(config.DefaultTTL)
-	case "rwmutex":
-		cache = inmemory.NewRWMutexCache(config.DefaultTTL)
-	case "sharded":
-		if config.Shards <= 0 || config.ShardCapacity <= 0 {
-			return nil, errors.New("shards and shardCapacity must be greater than zero")
-		}
-		cache = inmemory.NewShardedCache(config.Shards, config.DefaultTTL, config.ShardCapacity)
-	default:
-		return nil, errors.New("unsupported backend")
+	// Snapshot the in-memory backend if a snapshot file is configured instead of a repository
+	if !config.EnablePersistence && config.PersistenceFilePath != "" && config.PersistenceFlushInterval > 0 {
+		snapshots, err := newSnapshotCache(config, backend)
+		if err != nil {
+			log.Printf("Failed to initialize cache snapshots: %v", err)
+			cache.Close()
+			return nil, err
+		}
+		if restored, err := snapshots.Restored(); err == nil {
+			log.Printf("Cache restored with %d entries from snapshot.", restored)
+		}
+		cache = snapshots
 	}
 
 	// Add persistence if enabled
 	if config.EnablePersistence {
-		var repo repository.Repository
-		//var serializer serialization.Serializer
-
-		var err error
-
-		switch config.DatabaseType {
-		case "sqlite":
-			// serializer = &serialization.BlobSerializer{}
-			//  repo, err = repository.NewSQLiteRepository(config.DatabaseDSN, serializer)
-			repo, err = repository.NewSQLiteRepository(config.DatabaseDSN)
-		case "postgres":
-			//serializer = &serialization.JSONSerializer{}
-			// repo, err = repository.NewPostgresRepository(config.DatabaseDSN, serializer)
-			repo, err = repository.NewPostgresRepository(config.DatabaseDSN)
-		default:
-			return nil, errors.New("unsupported database type")
+		serializer, err := newSerializer(config)
+		if err != nil {
+			cache.Close()
+			return nil, err
 		}
-
+		dsn := config.DatabaseDSN
+		if config.DatabaseType == "file" {
+			dsn = config.PersistenceFilePath
+		}
+		repo, err := newRepository(config, dsn, serializer)
 		if err != nil {
 			log.Printf("Failed to initialize persistence repository: %v", err)
+			cache.Close()
 			return nil, err
 		}
 
-		cache = persistence.NewPersistentCache(cache, repo)
-		log.Println("Persistence layer enabled.")
+		var persistent *persistence.PersistentCache
+		if config.WriteBehind {
+			backpressure, err := persistence.ParseBackpressurePolicy(config.PersistenceBackpressure)
+			if err != nil {
+				cache.Close()
+				repo.Close()
+				return nil, err
+			}
+			deadLetters, err := newDeadLetterStore(config, serializer)
+			if err != nil {
+				log.Printf("Failed to initialize dead-letter store: %v", err)
+				cache.Close()
+				repo.Close()
+				return nil, err
+			}
+			retryLimit := config.PersistenceRetryLimit
+			if retryLimit <= 0 {
+				retryLimit = defaultPersistenceRetryLimit
+			}
+			retry := persistence.NewBackoffPolicy(retryLimit)
+			if config.PersistenceRetryDelay > 0 {
+				retry.InitialDelay = config.PersistenceRetryDelay
+			}
+			if config.PersistenceRetryMaxDelay > 0 {
+				retry.MaxDelay = config.PersistenceRetryMaxDelay
+			}
+			asyncConfig := persistence.AsyncPersistenceConfig{
+				QueueSize:      config.PersistenceQueueSize,
+				Retry:          retry,
+				Workers:        config.PersistenceWorkers,
+				FlushInterval:  config.PersistenceFlushInterval,
+				BatchSize:      config.PersistenceBatchSize,
+				Backpressure:   backpressure,
+				EnqueueTimeout: config.PersistenceEnqueueTimeout,
+				OverflowPath:   config.PersistenceOverflowPath,
+				Serializer:     serializer,
+				DeadLetters:    deadLetters,
+			}
+			if asyncConfig.QueueSize <= 0 {
+				asyncConfig.QueueSize = defaultPersistenceQueueSize
+			}
+			manager, err := persistence.NewAsyncPersistenceManager(repo, asyncConfig)
+			if err != nil {
+				log.Printf("Failed to start write-behind persistence: %v", err)
+				if closer, ok := deadLetters.(io.Closer); ok {
+					closer.Close()
+				}
+				cache.Close()
+				repo.Close()
+				return nil, err
+			}
+			persistent = persistence.NewWriteBehindCache(cache, repo, manager)
+			log.Println("Persistence layer enabled in write-behind mode.")
+		} else {
+			persistent = persistence.NewPersistentCache(cache, repo)
+			log.Println("Persistence layer enabled.")
+		}
+
+		if config.WarmUpOnStart {
+			batchSize := config.WarmUpBatchSize
+			if batchSize <= 0 {
+				batchSize = defaultWarmUpBatchSize
+			}
+			// A failed warm-up leaves a usable, if colder, cache.
+			loaded, err := persistent.WarmUp(batchSize)
+			if err != nil {
+				log.Printf("Cache warm-up stopped after %d entries: %v", loaded, err)
+			} else {
+				log.Printf("Cache warmed up with %d entries.", loaded)
+			}
+		}
+		cache = persistent
 	}
 
 	log.Println("Cache successfully initialized.")
 	return cache, nil
 }
+
+// newRepository opens the repository for config.DatabaseType at dsn; for
+// "file", dsn is the path of the log file. A nil serializer selects the
+// repository's default: gob for SQLite and files, and JSON for Postgres.
+func newRepository(config CacheConfig, dsn string, serializer serialization.Serializer) (repository.Repository, error) {
+	switch config.DatabaseType {
+	case "sqlite":
+		repo, err := repository.NewSQLiteRepository(dsn, serializer)
+		if err != nil {
+			return nil, err
+		}
+		repo.DeleteCorrupt = config.DeleteCorruptValues
+		return repo, nil
+	case "postgres":
+		if config.MigratePostgresValues {
+			if err := repository.MigratePostgresValueColumn(dsn); err != nil {
+				return nil, err
+			}
+		}
+		repo, err := repository.NewPostgresRepository(dsn, serializer)
+		if err != nil {
+			return nil, err
+		}
+		repo.DeleteCorrupt = config.DeleteCorruptValues
+		return repo, nil
+	case "file":
+		repo, err := repository.NewFileRepository(dsn, serializer)
+		if err != nil {
+			return nil, err
+		}
+		repo.DeleteCorrupt = config.DeleteCorruptValues
+		return repo, nil
+	default:
+		return nil, errors.New("unsupported database type")
+	}
+}
+
+// newSnapshotCache wraps backend with periodic snapshots to PersistenceFilePath.
+func newSnapshotCache(config CacheConfig, backend interfaces.Cache) (*persistence.SnapshotCache, error) {
+	snapshotable, ok := backend.(persistence.SnapshotableCache)
+	if !ok {
+		return nil, errors.New("backend does not support snapshots")
+	}
+	serializer, err := newSerializer(config)
+	if err != nil {
+		return nil, err
+	}
+	return persistence.NewSnapshotCache(snapshotable, persistence.SnapshotConfig{
+		Path:        config.PersistenceFilePath,
+		Interval:    config.PersistenceFlushInterval,
+		Generations: config.PersistenceSnapshots,
+		Serializer:  serializer,
+		StartEmpty:  config.StartWithoutSnapshot,
+	})
+}
+
+// newSerializer creates the Serializer selected by Serializer, Compression and
+// EncryptionKeys. Values are compressed before they are encrypted, since
+// ciphertext does not compress.
+func newSerializer(config CacheConfig) (serialization.Serializer, error) {
+	serializer, err := newBaseSerializer(config.Serializer, config.DatabaseType)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Compression != "" {
+		codec, ok := serialization.LookupCodec(config.Compression)
+		if !ok {
+			return nil, errors.New("unsupported compression codec")
+		}
+		serializer = serialization.NewCompressedSerializer(serializer, codec, config.CompressionThreshold)
+	}
+	if len(config.EncryptionKeys) > 0 {
+		encrypted, err := serialization.NewEncryptedSerializer(serializer, config.EncryptionKeyID, config.EncryptionKeys)
+		if err != nil {
+			return nil, err
+		}
+		encrypted.ReadPlaintext = config.EncryptionReadPlaintext
+		return encrypted, nil
+	}
+	return serializer, nil
+}
+
+// newBaseSerializer creates the Serializer selected by name. An empty name
+// selects JSON for Postgres and gob otherwise.
+func newBaseSerializer(name, databaseType string) (serialization.Serializer, error) {
+	if name == "" {
+		name = "gob"
+		if databaseType == "postgres" {
+			name = "json"
+		}
+	}
+
+	switch name {
+	case "gob":
+		return &serialization.BlobSerializer{}, nil
+	case "json":
+		return &serialization.JSONSerializer{}, nil
+	case "msgpack":
+		return &serialization.MsgPackSerializer{}, nil
+	case "cbor":
+		return &serialization.CBORSerializer{}, nil
+	default:
+		return nil, errors.New("unsupported serializer")
+	}
+}
+
+// newDeadLetterStore creates the dead-letter store selected by
+// PersistenceDeadLetter, or nil if none is selected.
+func newDeadLetterStore(config CacheConfig, serializer serialization.Serializer) (persistence.DeadLetterStore, error) {
+	switch config.PersistenceDeadLetter {
+	case "":
+		return nil, nil
+	case "memory":
+		return persistence.NewRingDeadLetterStore(config.PersistenceDeadLetterSize), nil
+	case "file":
+		return persistence.NewFileDeadLetterStore(config.PersistenceDeadLetterPath, serializer)
+	case "repository":
+		// The store uses the same table as the cache, so it needs its own database.
+		dsn := config.DatabaseDSN
+		if config.DatabaseType == "file" {
+			dsn = config.PersistenceFilePath
+		}
+		if config.PersistenceDeadLetterDSN == "" || config.PersistenceDeadLetterDSN == dsn {
+			return nil, errors.New("repository dead-letter store requires a PersistenceDeadLetterDSN other than the cache database")
+		}
+		repo, err := newRepository(config, config.PersistenceDeadLetterDSN, serializer)
+		if err != nil {
+			return nil, err
+		}
+		return persistence.NewRepositoryDeadLetterStore(repo, serializer), nil
+	default:
+		return nil, errors.New("unsupported dead-letter store")
+	}
+}
@@ -1,9 +1,9 @@
-
 // File: cachefy_test.go
 
 package cachefy
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -30,3 +30,102 @@ func TestNewCache(t *testing.T) {
 		t.Fatalf("Failed to get cache value: %v", err)
 	}
 }
+
+func TestNewCacheFilePersistence(t *testing.T) {
+	config := CacheConfig{
+		DefaultTTL:          5 * time.Minute,
+		Backend:             "rwmutex",
+		EnablePersistence:   true,
+		DatabaseType:        "file",
+		PersistenceFilePath: filepath.Join(t.TempDir(), "cache.log"),
+	}
+
+	cache, err := NewCache(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if err := cache.Set("test", "value"); err != nil {
+		t.Fatalf("Failed to set cache value: %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Failed to close cache: %v", err)
+	}
+
+	// Test that a new cache reads the value back from the log file
+	cache, err = NewCache(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer cache.Close()
+
+	value, err := cache.Get("test")
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value' from the log file, got %v, error: %v", value, err)
+	}
+}
+
+func TestNewCacheDeadLetterDSN(t *testing.T) {
+	config := CacheConfig{
+		DefaultTTL:            5 * time.Minute,
+		Backend:               "rwmutex",
+		EnablePersistence:     true,
+		DatabaseType:          "file",
+		PersistenceFilePath:   filepath.Join(t.TempDir(), "cache.log"),
+		WriteBehind:           true,
+		PersistenceDeadLetter: "repository",
+	}
+
+	// Test that the repository dead-letter store needs its own database
+	for _, dsn := range []string{"", config.PersistenceFilePath} {
+		config.PersistenceDeadLetterDSN = dsn
+		if cache, err := NewCache(config); err == nil {
+			cache.Close()
+			t.Errorf("Expected NewCache to fail with dead-letter DSN %q", dsn)
+		}
+	}
+
+	config.PersistenceDeadLetterDSN = filepath.Join(t.TempDir(), "dead_letters.log")
+	cache, err := NewCache(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache.Close()
+}
+
+func TestNewCacheSnapshots(t *testing.T) {
+	config := CacheConfig{
+		DefaultTTL:               5 * time.Minute,
+		Backend:                  "sharded",
+		Shards:                   4,
+		ShardCapacity:            100,
+		PersistenceFilePath:      filepath.Join(t.TempDir(), "cache.snapshot"),
+		PersistenceFlushInterval: time.Hour,
+	}
+
+	cache, err := NewCache(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache.Set("test", "value")
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Failed to close cache: %v", err)
+	}
+
+	// Test that a new cache is restored from the snapshot written on Close
+	cache, err = NewCache(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer cache.Close()
+
+	value, err := cache.Get("test")
+	if err != nil || value != "value" {
+		t.Fatalf("Expected 'value' from the snapshot, got %v, error: %v", value, err)
+	}
+
+	// Test that a snapshot that cannot be restored fails NewCache
+	config.Serializer = "json"
+	if _, err := NewCache(config); err == nil {
+		t.Errorf("Expected NewCache to fail with an unreadable snapshot")
+	}
+}
@@ -1,10 +1,28 @@
 package interfaces
 
+import "time"
+
+// NoExpiration is returned by Cache.TTL for entries that never expire.
+const NoExpiration time.Duration = -1
+
 type Cache interface {
     Get(key string) (interface{}, error)
     Set(key string, value interface{}) error
     Delete(key string) error
     Clear() error
+
+    // SetWithTTL stores value under key for ttl; a ttl <= 0 stores it without expiry.
+    SetWithTTL(key string, value interface{}, ttl time.Duration) error
+    // TTL returns the remaining lifetime of key, or NoExpiration if it never expires.
+    TTL(key string) (time.Duration, error)
+    // Touch resets the lifetime of an existing key to ttl; a ttl <= 0 removes its expiry.
+    Touch(key string, ttl time.Duration) error
+    // Persist removes the expiry of an existing key.
+    Persist(key string) error
+
+    // Close releases the cache's resources, such as background goroutines and
+    // database connections. The cache must not be used afterwards.
+    Close() error
 }
 
 type Repository interface {
@@ -0,0 +1,97 @@
+// File: loader.go
+
+package cachefy
+
+import (
+	"cachefy/backends/inmemory"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// LoadingCache wraps a Cache with GetOrLoad, which fills misses from a loader
+// function and coalesces concurrent misses for the same key into one call.
+type LoadingCache[K comparable, V any] struct {
+	Cache[K, V]
+	mutex sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+// loadCall is an in-flight or completed loader call shared by all waiters.
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// NewLoadingCache wraps cache. An untyped interfaces.Cache can be wrapped as
+// NewLoadingCache[string, interface{}](cache).
+func NewLoadingCache[K comparable, V any](cache Cache[K, V]) *LoadingCache[K, V] {
+	return &LoadingCache[K, V]{
+		Cache: cache,
+		calls: make(map[K]*loadCall[V]),
+	}
+}
+
+// GetOrLoad returns the cached value for key. On a miss it calls loader, stores
+// the result with the cache's default TTL and returns it. Concurrent misses for
+// the same key wait for the first caller's loader instead of calling their own;
+// the loader runs with that first caller's context, while each waiter stops
+// waiting when its own ctx is done. Loader errors are returned to every waiter
+// and are not cached. A panic in the loader is recovered and returned the same
+// way, as an error naming the panic value.
+func (c *LoadingCache[K, V]) GetOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, error)) (V, error) {
+	var zero V
+	value, err := c.Get(key)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, inmemory.ErrCacheMiss) {
+		return zero, err
+	}
+
+	c.mutex.Lock()
+	call, inflight := c.calls[key]
+	if !inflight {
+		call = &loadCall[V]{done: make(chan struct{})}
+		c.calls[key] = call
+	}
+	c.mutex.Unlock()
+
+	if !inflight {
+		c.load(ctx, key, call, loader)
+		return call.value, call.err
+	}
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// load runs loader on behalf of every caller waiting on call.
+func (c *LoadingCache[K, V]) load(ctx context.Context, key K, call *loadCall[V], loader func(ctx context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("cache loader panicked: %v", r)
+		}
+		c.mutex.Lock()
+		delete(c.calls, key)
+		c.mutex.Unlock()
+		close(call.done)
+	}()
+
+	// Another caller may have stored the value between our miss and taking the lead.
+	if value, err := c.Get(key); err == nil {
+		call.value = value
+		return
+	}
+
+	call.value, call.err = loader(ctx)
+	if call.err == nil {
+		call.err = c.Set(key, call.value)
+	}
+}
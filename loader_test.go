@@ -0,0 +1,60 @@
+// File: loader_test.go
+
+package cachefy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	cache, err := NewTypedCache[string, int](CacheConfig{DefaultTTL: 5 * time.Minute, Backend: "rwmutex"})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	loading := NewLoadingCache(cache)
+
+	// Test coalescing of concurrent misses
+	var calls int32
+	loader := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := loading.GetOrLoad(context.Background(), "key1", loader)
+			if err != nil || value != 42 {
+				t.Errorf("Expected 42, got %v, error: %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected loader to be called once, got %d", calls)
+	}
+	if value, err := cache.Get("key1"); err != nil || value != 42 {
+		t.Errorf("Expected loaded value to be cached, got %v, error: %v", value, err)
+	}
+
+	// Test that errors are not cached
+	errLoad := errors.New("database unavailable")
+	_, err = loading.GetOrLoad(context.Background(), "key2", func(ctx context.Context) (int, error) {
+		return 0, errLoad
+	})
+	if err != errLoad {
+		t.Errorf("Expected loader error, got %v", err)
+	}
+	if _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected cache miss after failed load")
+	}
+}
@@ -3,57 +3,944 @@
 package persistence
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cachefy/repository"
+	"cachefy/serialization"
 )
 
+// BackpressurePolicy selects what Enqueue does when the task queue is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock waits for room in the queue until the context passed
+	// to Enqueue is done.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropNewest discards the task being enqueued.
+	BackpressureDropNewest
+	// BackpressureDropOldest discards the oldest queued task to make room.
+	BackpressureDropOldest
+	// BackpressureSpill appends the task to an on-disk overflow file, which
+	// the worker drains once the queue has room again.
+	BackpressureSpill
+)
+
+// ErrNoDeadLetterStore is returned by the dead-letter API of a manager
+// configured without a DeadLetterStore.
+var ErrNoDeadLetterStore = errors.New("no dead-letter store configured")
+
+// ErrManagerClosed is returned for writes enqueued after Shutdown has begun.
+var ErrManagerClosed = errors.New("persistence manager is shut down")
+
+// ErrUnsupportedBackpressurePolicy is returned for an unknown policy name.
+var ErrUnsupportedBackpressurePolicy = errors.New("unsupported backpressure policy")
+
+// ParseBackpressurePolicy returns the policy for a name: "block", "drop-newest",
+// "drop-oldest" or "spill". An empty name selects "block".
+func ParseBackpressurePolicy(name string) (BackpressurePolicy, error) {
+	switch name {
+	case "", "block":
+		return BackpressureBlock, nil
+	case "drop-newest":
+		return BackpressureDropNewest, nil
+	case "drop-oldest":
+		return BackpressureDropOldest, nil
+	case "spill":
+		return BackpressureSpill, nil
+	default:
+		return BackpressureBlock, ErrUnsupportedBackpressurePolicy
+	}
+}
+
+// EnqueueResult tells the caller of Enqueue what happened to its task.
+type EnqueueResult int
+
+const (
+	// Enqueued means the task was queued.
+	Enqueued EnqueueResult = iota
+	// DroppedNewest means the queue was full and the task was discarded.
+	DroppedNewest
+	// DroppedOldest means the task was queued after discarding the oldest one.
+	DroppedOldest
+	// Spilled means the task was written to the overflow file.
+	Spilled
+	// TimedOut means the context was done before the queue had room; the task
+	// was discarded.
+	TimedOut
+	// Rejected means the manager is shut down; the task was discarded.
+	Rejected
+)
+
+// AsyncPersistenceStats counts the outcomes of Enqueue calls.
+type AsyncPersistenceStats struct {
+	Enqueued      uint64
+	DroppedNewest uint64
+	DroppedOldest uint64
+	Spilled       uint64
+	TimedOut      uint64
+	Rejected      uint64
+}
+
+// AsyncPersistenceConfig configures an AsyncPersistenceManager.
+type AsyncPersistenceConfig struct {
+	QueueSize      int                      // Capacity of the task queue, split evenly between workers
+	RetryLimit     int                      // Attempts per batch before it is dropped, when Retry is nil
+	Retry          RetryPolicy              // Decides when failed writes are retried; defaults to NewBackoffPolicy(RetryLimit)
+	Workers        int                      // Goroutines writing to the repository; defaults to 1
+	FlushInterval  time.Duration            // How often pending writes are flushed; zero flushes whenever the queue is drained
+	BatchSize      int                      // Pending keys that trigger a flush before the interval elapses; defaults to 100
+	Backpressure   BackpressurePolicy       // What Enqueue does when the queue is full
+	EnqueueTimeout time.Duration            // How long PersistentCache waits for room under BackpressureBlock; zero waits indefinitely
+	OverflowPath   string                   // Overflow file for BackpressureSpill
+	Serializer     serialization.Serializer // Encoding of values in the overflow file; nil selects gob
+	DeadLetters    DeadLetterStore          // Receives writes that exhaust their retries; nil only logs them. Closed by Shutdown if it is an io.Closer
+}
+
+const defaultBatchSize = 100
+
+type taskOp int
+
+const (
+	opSet taskOp = iota
+	opDelete
+)
+
+// persistenceTask is a single queued repository write. seq orders tasks
+// across the queue, the overflow file and clears.
+type persistenceTask struct {
+	seq   uint64
+	op    taskOp
+	key   string
+	entry *repository.CacheEntry
+}
+
+// persistenceWorker owns the tasks for the keys that hash to it, so writes to
+// one key are applied in order while other workers carry on independently.
+type persistenceWorker struct {
+	queue chan *persistenceTask
+	wake  chan struct{}
+
+	// inbox holds spilled and recovered tasks routed to this worker.
+	inboxMutex sync.Mutex
+	inbox      []*persistenceTask
+}
+
+// take returns and empties the inbox.
+func (w *persistenceWorker) take() []*persistenceTask {
+	w.inboxMutex.Lock()
+	defer w.inboxMutex.Unlock()
+
+	tasks := w.inbox
+	w.inbox = nil
+	return tasks
+}
+
+// deliver adds task to the inbox and wakes the worker, which may be idle.
+func (w *persistenceWorker) deliver(task *persistenceTask) {
+	w.inboxMutex.Lock()
+	w.inbox = append(w.inbox, task)
+	w.inboxMutex.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
 type AsyncPersistenceManager struct {
-	repo       repository.Repository
-	taskQueue  chan *repository.CacheEntry
-	retryLimit int
-	wg         sync.WaitGroup
+	repo           repository.Repository
+	workers        []*persistenceWorker
+	retryPolicy    RetryPolicy
+	flushInterval  time.Duration
+	batchSize      int
+	backpressure   BackpressurePolicy
+	enqueueTimeout time.Duration
+	spill          *spillFile
+	seq            uint64
+	wg             sync.WaitGroup
+
+	// done is closed when Shutdown begins, aborted when its context ends and
+	// stopped once the workers have exited. closed is set, and the queues
+	// closed, once no enqueue is in progress.
+	stopping   int32
+	closeMutex sync.RWMutex
+	closed     bool
+	done       chan struct{}
+	aborted    chan struct{}
+	stopped    chan struct{}
+
+	// writeMutex is held shared by workers writing batches and exclusively
+	// while a clear is applied or dead letters are replayed.
+	writeMutex sync.RWMutex
+
+	// deadKeys holds the keys with a dead letter, and deadClear whether there
+	// is a clear letter, so that a successful write only touches the store
+	// when it supersedes one.
+	deadMutex   sync.Mutex
+	deadLetters DeadLetterStore
+	deadKeys    map[string]struct{}
+	deadClear   bool
+
+	// pending counts queued or unflushed tasks per key, so that readers can
+	// tell when the repository is behind the cache. flushedSeq holds the
+	// sequence number of the latest task flushed for each pending key, so that
+	// an older task reaching its worker late, such as a spilled one, is
+	// discarded rather than written over a newer one. A clear is pending while
+	// clearSeq is ahead of appliedClearSeq; tasks sequenced before clearSeq
+	// are superseded by it.
+	mutex           sync.Mutex
+	pending         map[string]int
+	flushedSeq      map[string]uint64
+	clearSeq        uint64
+	appliedClearSeq uint64
+
+	enqueued      uint64
+	droppedNewest uint64
+	droppedOldest uint64
+	spilled       uint64
+	timedOut      uint64
+	rejected      uint64
+	failed        uint64 // Tasks whose batch exhausted its retries
+	abandoned     uint64 // Tasks not written because Shutdown gave up
 }
 
-func NewAsyncPersistenceManager(repo repository.Repository, queueSize, retryLimit int) *AsyncPersistenceManager {
+// NewAsyncPersistenceManager creates a manager and starts its worker. With
+// BackpressureSpill it opens config.OverflowPath and requeues any tasks left
+// there by a previous run.
+func NewAsyncPersistenceManager(repo repository.Repository, config AsyncPersistenceConfig) (*AsyncPersistenceManager, error) {
+	retryPolicy := config.Retry
+	if retryPolicy == nil {
+		retryPolicy = NewBackoffPolicy(config.RetryLimit)
+	}
+	batchSize := config.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBatchSize
+	}
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
 	manager := &AsyncPersistenceManager{
-		repo:       repo,
-		taskQueue:  make(chan *repository.CacheEntry, queueSize),
-		retryLimit: retryLimit,
+		repo:           repo,
+		retryPolicy:    retryPolicy,
+		flushInterval:  config.FlushInterval,
+		batchSize:      batchSize,
+		backpressure:   config.Backpressure,
+		enqueueTimeout: config.EnqueueTimeout,
+		done:           make(chan struct{}),
+		aborted:        make(chan struct{}),
+		stopped:        make(chan struct{}),
+		deadLetters:    config.DeadLetters,
+		deadKeys:       make(map[string]struct{}),
+		pending:        make(map[string]int),
+		flushedSeq:     make(map[string]uint64),
+	}
+	queueSize := (config.QueueSize + workers - 1) / workers
+	for i := 0; i < workers; i++ {
+		manager.workers = append(manager.workers, &persistenceWorker{
+			queue: make(chan *persistenceTask, queueSize),
+			wake:  make(chan struct{}, 1),
+		})
+	}
+
+	if manager.deadLetters != nil {
+		letters, err := manager.deadLetters.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, letter := range letters {
+			manager.noteDeadLetter(letter)
+		}
+	}
+
+	if config.Backpressure == BackpressureSpill {
+		if config.OverflowPath == "" {
+			return nil, errors.New("spill backpressure requires an overflow path")
+		}
+		spill, err := openSpillFile(config.OverflowPath, config.Serializer)
+		if err != nil {
+			return nil, err
+		}
+		recovered, _, err := spill.drain()
+		if err != nil {
+			spill.Close()
+			return nil, err
+		}
+		// Leftover tasks predate everything enqueued from now on.
+		for _, task := range recovered {
+			task.seq = manager.nextSeq()
+			manager.track(task, 1)
+			manager.workerFor(task.key).deliver(task)
+		}
+		manager.spill = spill
 	}
 
 	// Start background workers
-	manager.wg.Add(1)
-	go manager.worker()
+	for _, worker := range manager.workers {
+		manager.wg.Add(1)
+		go manager.work(worker)
+	}
+
+	return manager, nil
+}
+
+// Enqueue adds a cache entry to the persistence queue. When the queue is full
+// the configured BackpressurePolicy decides the outcome; ctx bounds the wait
+// under BackpressureBlock.
+func (m *AsyncPersistenceManager) Enqueue(ctx context.Context, entry *repository.CacheEntry) (EnqueueResult, error) {
+	return m.submit(ctx, m.prepare(opSet, entry.Key, entry))
+}
+
+// EnqueueDelete queues the removal of key from the repository.
+func (m *AsyncPersistenceManager) EnqueueDelete(ctx context.Context, key string) (EnqueueResult, error) {
+	return m.submit(ctx, m.prepare(opDelete, key, nil))
+}
+
+// EnqueueClear schedules the removal of all entries from the repository.
+// It never waits for room: every task enqueued before it is discarded, and
+// the worker clears the repository before writing anything enqueued after it.
+func (m *AsyncPersistenceManager) EnqueueClear() error {
+	m.closeMutex.RLock()
+	defer m.closeMutex.RUnlock()
+
+	if m.closed {
+		atomic.AddUint64(&m.rejected, 1)
+		return ErrManagerClosed
+	}
+
+	m.mutex.Lock()
+	m.clearSeq = m.nextSeq()
+	m.mutex.Unlock()
 
-	return manager
+	select {
+	case m.workers[0].wake <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
-// Enqueue adds a cache entry to the persistence queue.
-func (m *AsyncPersistenceManager) Enqueue(entry *repository.CacheEntry) {
-	m.taskQueue <- entry
+// Pending reports whether writes affecting key are queued but not yet applied.
+func (m *AsyncPersistenceManager) Pending(key string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.clearSeq > m.appliedClearSeq || m.pending[key] > 0
+}
+
+// Stats returns the outcome counts of all Enqueue and EnqueueDelete calls so far.
+func (m *AsyncPersistenceManager) Stats() AsyncPersistenceStats {
+	return AsyncPersistenceStats{
+		Enqueued:      atomic.LoadUint64(&m.enqueued),
+		DroppedNewest: atomic.LoadUint64(&m.droppedNewest),
+		DroppedOldest: atomic.LoadUint64(&m.droppedOldest),
+		Spilled:       atomic.LoadUint64(&m.spilled),
+		TimedOut:      atomic.LoadUint64(&m.timedOut),
+		Rejected:      atomic.LoadUint64(&m.rejected),
+	}
 }
 
-// worker processes persistence tasks from the queue.
-func (m *AsyncPersistenceManager) worker() {
+func (m *AsyncPersistenceManager) nextSeq() uint64 {
+	return atomic.AddUint64(&m.seq, 1)
+}
+
+// workerFor returns the worker that owns key.
+func (m *AsyncPersistenceManager) workerFor(key string) *persistenceWorker {
+	if len(m.workers) == 1 {
+		return m.workers[0]
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return m.workers[hash.Sum32()%uint32(len(m.workers))]
+}
+
+// prepare creates a task and sequences it, marking its key as pending. Writes
+// to a key take effect in the order they are prepared, whatever the order in
+// which they are then submitted, so a caller can prepare a write while it
+// holds a lock on the key and wait for room in the queue after releasing it.
+func (m *AsyncPersistenceManager) prepare(op taskOp, key string, entry *repository.CacheEntry) *persistenceTask {
+	task := &persistenceTask{op: op, key: key, entry: entry}
+	m.track(task, 1)
+	task.seq = m.nextSeq()
+	return task
+}
+
+// submit hands a prepared task to its worker, applying the backpressure
+// policy if the worker's queue is full.
+func (m *AsyncPersistenceManager) submit(ctx context.Context, task *persistenceTask) (EnqueueResult, error) {
+	m.closeMutex.RLock()
+	defer m.closeMutex.RUnlock()
+
+	if m.closed {
+		m.track(task, -1)
+		atomic.AddUint64(&m.rejected, 1)
+		return Rejected, ErrManagerClosed
+	}
+	queue := m.workerFor(task.key).queue
+
+	select {
+	case queue <- task:
+		atomic.AddUint64(&m.enqueued, 1)
+		return Enqueued, nil
+	default:
+	}
+
+	switch m.backpressure {
+	case BackpressureDropNewest:
+		m.track(task, -1)
+		atomic.AddUint64(&m.droppedNewest, 1)
+		return DroppedNewest, nil
+	case BackpressureDropOldest:
+		for {
+			select {
+			case oldest := <-queue:
+				m.track(oldest, -1)
+				atomic.AddUint64(&m.droppedOldest, 1)
+			default:
+			}
+			// Another producer may take the freed slot first; keep evicting.
+			select {
+			case queue <- task:
+				atomic.AddUint64(&m.enqueued, 1)
+				return DroppedOldest, nil
+			default:
+			}
+		}
+	case BackpressureSpill:
+		if err := m.spill.append(task); err != nil {
+			m.track(task, -1)
+			atomic.AddUint64(&m.droppedNewest, 1)
+			return DroppedNewest, err
+		}
+		atomic.AddUint64(&m.spilled, 1)
+		return Spilled, nil
+	default:
+		select {
+		case queue <- task:
+			atomic.AddUint64(&m.enqueued, 1)
+			return Enqueued, nil
+		case <-ctx.Done():
+			m.track(task, -1)
+			atomic.AddUint64(&m.timedOut, 1)
+			return TimedOut, ctx.Err()
+		case <-m.done:
+			m.track(task, -1)
+			atomic.AddUint64(&m.rejected, 1)
+			return Rejected, ErrManagerClosed
+		}
+	}
+}
+
+// track adjusts the pending counter for task's key by delta.
+func (m *AsyncPersistenceManager) track(task *persistenceTask, delta int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.trackLocked(task, delta)
+}
+
+// trackLocked is track for callers that hold the mutex.
+func (m *AsyncPersistenceManager) trackLocked(task *persistenceTask, delta int) {
+	m.pending[task.key] += delta
+	if m.pending[task.key] == 0 {
+		delete(m.pending, task.key)
+		delete(m.flushedSeq, task.key)
+	}
+}
+
+// markFlushed records each task in batch as the latest flushed for its key,
+// and returns the keys whose tasks are older than one flushed before. Tasks
+// from the overflow file, or submitted some time after they were prepared,
+// can reach their worker after newer writes to the same key were flushed.
+func (m *AsyncPersistenceManager) markFlushed(batch *pendingBatch) map[string]bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var stale map[string]bool
+	for key, task := range batch.tasks {
+		if task.seq < m.flushedSeq[key] {
+			if stale == nil {
+				stale = make(map[string]bool)
+			}
+			stale[key] = true
+			continue
+		}
+		m.flushedSeq[key] = task.seq
+	}
+	return stale
+}
+
+// pendingBatch holds coalesced writes awaiting a flush: the latest task per
+// key, with deletes kept as tombstones.
+type pendingBatch struct {
+	tasks map[string]*persistenceTask
+}
+
+func newPendingBatch() *pendingBatch {
+	return &pendingBatch{tasks: make(map[string]*persistenceTask)}
+}
+
+// work coalesces tasks from worker's queue and flushes them when the batch
+// is full, on every tick of the flush interval, or, without an interval,
+// whenever the queue has been drained. Spilled tasks are picked up on the
+// same occasions, and tasks left over from a previous run are flushed on
+// start.
+func (m *AsyncPersistenceManager) work(worker *persistenceWorker) {
 	defer m.wg.Done()
 
-	for entry := range m.taskQueue {
-		for attempts := 0; attempts < m.retryLimit; attempts++ {
-			err := m.repo.Set(entry)
-			if err == nil {
-				break
+	var tick <-chan time.Time
+	if m.flushInterval > 0 {
+		ticker := time.NewTicker(m.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	batch := newPendingBatch()
+	m.collect(worker, batch)
+	if len(batch.tasks) > 0 {
+		m.flush(batch)
+		batch = newPendingBatch()
+	}
+	for {
+		select {
+		case task, ok := <-worker.queue:
+			if !ok {
+				m.collect(worker, batch)
+				m.flush(batch)
+				return
 			}
-			log.Printf("Persistence failed for key %s: %v (retry %d)", entry.Key, err, attempts+1)
-			time.Sleep(2 * time.Second)
+			m.coalesce(batch, task)
+			if len(batch.tasks) >= m.batchSize || (tick == nil && len(worker.queue) == 0) {
+				m.collect(worker, batch)
+				m.flush(batch)
+				batch = newPendingBatch()
+			}
+		case <-tick:
+			m.collect(worker, batch)
+			m.flush(batch)
+			batch = newPendingBatch()
+		case <-worker.wake:
+			m.collect(worker, batch)
+			if tick == nil {
+				m.flush(batch)
+				batch = newPendingBatch()
+			}
+		}
+	}
+}
+
+// collect drains the overflow file into the workers' inboxes and moves the
+// tasks in worker's inbox into batch. Once Shutdown has given up, spilled
+// tasks are left on disk for the next run.
+func (m *AsyncPersistenceManager) collect(worker *persistenceWorker, batch *pendingBatch) {
+	if m.spill != nil && m.spill.len() > 0 && !m.isAborted() {
+		tasks, discarded, err := m.spill.drain()
+		if err != nil {
+			log.Printf("Failed to read persistence overflow file: %v", err)
+		}
+		for _, key := range discarded {
+			m.track(&persistenceTask{key: key}, -1)
+		}
+		for _, task := range tasks {
+			m.workerFor(task.key).deliver(task)
+		}
+	}
+	for _, task := range worker.take() {
+		m.coalesce(batch, task)
+	}
+}
+
+// coalesce folds task into batch. The task with the higher sequence number
+// wins for each key, and tasks superseded by a clear are discarded.
+func (m *AsyncPersistenceManager) coalesce(batch *pendingBatch, task *persistenceTask) {
+	m.mutex.Lock()
+	clearSeq := m.clearSeq
+	m.mutex.Unlock()
+
+	if task.seq < clearSeq {
+		m.track(task, -1)
+		return
+	}
+	if existing, ok := batch.tasks[task.key]; ok {
+		if existing.seq > task.seq {
+			m.track(task, -1)
+			return
+		}
+		m.track(existing, -1)
+	}
+	batch.tasks[task.key] = task
+}
+
+// flush writes batch to the repository in a single WriteBatch call, after
+// applying any pending clear. Writes that exhaust their retries go to the
+// dead-letter store.
+func (m *AsyncPersistenceManager) flush(batch *pendingBatch) {
+	var failed, abandoned int
+	defer func() {
+		m.settle(batch, failed, abandoned)
+	}()
+	if m.isAborted() {
+		abandoned = len(batch.tasks)
+		return
+	}
+
+	// Hold writeMutex shared once no clear is pending. A clear registered
+	// later is sequenced after every task in batch, so it waits for this
+	// write and then supersedes it.
+	var clearSeq uint64
+	for {
+		m.writeMutex.RLock()
+		m.mutex.Lock()
+		clearSeq = m.clearSeq
+		applied := clearSeq == m.appliedClearSeq
+		m.mutex.Unlock()
+		if applied {
+			break
+		}
+		m.writeMutex.RUnlock()
+		m.applyClear()
+	}
+	defer m.writeMutex.RUnlock()
+
+	stale := m.markFlushed(batch)
+	var entries []*repository.CacheEntry
+	var deletes []string
+	var letters []*DeadLetter
+	for key, task := range batch.tasks {
+		if task.seq < clearSeq || stale[key] {
+			continue
+		}
+		if task.op == opDelete {
+			deletes = append(deletes, key)
+			letters = append(letters, &DeadLetter{Op: DeadLetterDelete, Key: key})
+		} else {
+			entries = append(entries, task.entry)
+			letters = append(letters, &DeadLetter{Op: DeadLetterSet, Key: key, Entry: task.entry})
+		}
+	}
+	if len(letters) == 0 {
+		return
+	}
+	attempts, err := m.retry(fmt.Sprintf("batch of %d keys", len(letters)), func() error {
+		return m.repo.WriteBatch(entries, deletes)
+	})
+	if err != nil {
+		failed = len(letters)
+		m.deadLetter(letters, attempts, err)
+	} else {
+		m.forgetDeadLetters(letters)
+	}
+}
+
+// settle untracks the tasks of a flushed batch and counts those that failed
+// or were abandoned, in one step so that Shutdown never counts a task both as
+// pending and as lost.
+func (m *AsyncPersistenceManager) settle(batch *pendingBatch, failed, abandoned int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	atomic.AddUint64(&m.failed, uint64(failed))
+	atomic.AddUint64(&m.abandoned, uint64(abandoned))
+	for _, task := range batch.tasks {
+		m.trackLocked(task, -1)
+	}
+}
+
+func (m *AsyncPersistenceManager) isAborted() bool {
+	select {
+	case <-m.aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// applyClear clears the repository if a clear is pending, while no worker
+// is writing.
+func (m *AsyncPersistenceManager) applyClear() {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	m.mutex.Lock()
+	clearSeq, appliedClearSeq := m.clearSeq, m.appliedClearSeq
+	m.mutex.Unlock()
+	if clearSeq == appliedClearSeq {
+		return
+	}
+
+	attempts, err := m.retry("clear", m.repo.Clear)
+	if err != nil {
+		m.deadLetter([]*DeadLetter{{Op: DeadLetterClear}}, attempts, err)
+	} else {
+		m.resetDeadLetters()
+	}
+	m.mutex.Lock()
+	m.appliedClearSeq = clearSeq
+	m.mutex.Unlock()
+}
+
+// retry calls write until it succeeds, the retry policy gives up or Shutdown
+// gives up. It returns the number of attempts made and the last error.
+func (m *AsyncPersistenceManager) retry(what string, write func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := write()
+		if err == nil {
+			return attempt, nil
+		}
+		delay, ok := m.retryPolicy.Next(attempt, err)
+		if !ok {
+			log.Printf("Persistence failed for %s: %v (giving up after %d attempts)", what, err, attempt)
+			return attempt, err
+		}
+		log.Printf("Persistence failed for %s: %v (retry %d in %v)", what, err, attempt, delay)
+		select {
+		case <-time.After(delay):
+		case <-m.aborted:
+			return attempt, err
+		}
+	}
+}
+
+// deadLetter records letters that failed after attempts with err.
+func (m *AsyncPersistenceManager) deadLetter(letters []*DeadLetter, attempts int, err error) {
+	if m.deadLetters == nil {
+		return
+	}
+	m.deadMutex.Lock()
+	defer m.deadMutex.Unlock()
+
+	now := time.Now()
+	for _, letter := range letters {
+		letter.LastError = err.Error()
+		letter.Attempts = attempts
+		letter.FailedAt = now
+	}
+	if err := m.deadLetters.Put(letters); err != nil {
+		log.Printf("Failed to record %d dead letters: %v", len(letters), err)
+		return
+	}
+	for _, letter := range letters {
+		m.noteDeadLetter(letter)
+	}
+}
+
+// noteDeadLetter records that the store holds letter. The caller must hold
+// deadMutex, or have exclusive access while starting.
+func (m *AsyncPersistenceManager) noteDeadLetter(letter *DeadLetter) {
+	if letter.Op == DeadLetterClear {
+		m.deadClear = true
+	} else {
+		m.deadKeys[letter.Key] = struct{}{}
+	}
+}
+
+// forgetDeadLetters removes the letters superseded by a successful write of
+// letters.
+func (m *AsyncPersistenceManager) forgetDeadLetters(letters []*DeadLetter) {
+	m.deadMutex.Lock()
+	defer m.deadMutex.Unlock()
+
+	if len(m.deadKeys) == 0 {
+		return
+	}
+	var keys []string
+	for _, letter := range letters {
+		if _, ok := m.deadKeys[letter.Key]; ok {
+			keys = append(keys, letter.Key)
 		}
 	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := m.deadLetters.Remove(keys); err != nil {
+		log.Printf("Failed to remove %d dead letters: %v", len(keys), err)
+		return
+	}
+	for _, key := range keys {
+		delete(m.deadKeys, key)
+	}
+}
+
+// resetDeadLetters removes all letters after a successful clear.
+func (m *AsyncPersistenceManager) resetDeadLetters() {
+	m.deadMutex.Lock()
+	defer m.deadMutex.Unlock()
+
+	if len(m.deadKeys) == 0 && !m.deadClear {
+		return
+	}
+	if err := m.deadLetters.Reset(); err != nil {
+		log.Printf("Failed to reset dead letters: %v", err)
+		return
+	}
+	m.deadKeys = make(map[string]struct{})
+	m.deadClear = false
+}
+
+// DeadLetters returns the writes that exhausted their retries and have not
+// been superseded or replayed since.
+func (m *AsyncPersistenceManager) DeadLetters() ([]*DeadLetter, error) {
+	if m.deadLetters == nil {
+		return nil, ErrNoDeadLetterStore
+	}
+	return m.deadLetters.List()
+}
+
+// ReplayDeadLetters writes the recorded letters back to the repository in a
+// single batch and forgets them, returning how many were replayed. Letters
+// for keys with queued writes are left for those writes to supersede, expired
+// entries are discarded, and clear letters are never replayed, since clearing
+// now would also discard every write made since the failure; they are removed
+// by the next successful clear or by DismissDeadLetters. If the write fails,
+// the letters are kept with the new error and attempt count.
+func (m *AsyncPersistenceManager) ReplayDeadLetters() (int, error) {
+	if m.deadLetters == nil {
+		return 0, ErrNoDeadLetterStore
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	letters, err := m.deadLetters.List()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var entries []*repository.CacheEntry
+	var deletes []string
+	var replayed, expired []*DeadLetter
+	for _, letter := range letters {
+		switch {
+		case letter.Op == DeadLetterClear || m.Pending(letter.Key):
+			continue
+		case letter.Op == DeadLetterDelete:
+			deletes = append(deletes, letter.Key)
+		case letter.Entry.Expired(now):
+			expired = append(expired, letter)
+			continue
+		default:
+			entries = append(entries, letter.Entry)
+		}
+		replayed = append(replayed, letter)
+	}
+	m.forgetDeadLetters(expired)
+	if len(replayed) == 0 {
+		return 0, nil
+	}
+
+	if err := m.repo.WriteBatch(entries, deletes); err != nil {
+		for _, letter := range replayed {
+			letter.Attempts++
+			letter.LastError = err.Error()
+			letter.FailedAt = now
+		}
+		m.deadMutex.Lock()
+		putErr := m.deadLetters.Put(replayed)
+		m.deadMutex.Unlock()
+		if putErr != nil {
+			log.Printf("Failed to update %d dead letters: %v", len(replayed), putErr)
+		}
+		return 0, err
+	}
+	m.forgetDeadLetters(replayed)
+	return len(replayed), nil
+}
+
+// DismissDeadLetters forgets all recorded letters, including clear letters,
+// without writing them to the repository.
+func (m *AsyncPersistenceManager) DismissDeadLetters() error {
+	if m.deadLetters == nil {
+		return ErrNoDeadLetterStore
+	}
+
+	m.deadMutex.Lock()
+	defer m.deadMutex.Unlock()
+
+	if err := m.deadLetters.Reset(); err != nil {
+		return err
+	}
+	m.deadKeys = make(map[string]struct{})
+	m.deadClear = false
+	return nil
+}
+
+// Shutdown stops accepting writes and flushes everything already queued,
+// including spilled tasks, until ctx is done. Writes enqueued once Shutdown
+// has begun fail with ErrManagerClosed.
+//
+// If ctx ends first, Shutdown returns at once and the workers stop retrying
+// and discard the tasks they have not written. A write already in progress is
+// left to finish in the background; Stopped reports when it has, and the
+// repository must not be closed before then.
+//
+// It returns the number of writes lost: those whose batch exhausted its
+// retries while draining and, if ctx ended first, those not yet written,
+// including any write still in progress, together with ctx's error. Tasks left
+// in the overflow file are kept for the next run and not counted.
+func (m *AsyncPersistenceManager) Shutdown(ctx context.Context) (int, error) {
+	if !atomic.CompareAndSwapInt32(&m.stopping, 0, 1) {
+		return 0, ErrManagerClosed
+	}
+	failed := atomic.LoadUint64(&m.failed)
+	abandoned := atomic.LoadUint64(&m.abandoned)
+
+	// Wake producers blocked on a full queue before waiting for them.
+	close(m.done)
+	m.closeMutex.Lock()
+	m.closed = true
+	for _, worker := range m.workers {
+		close(worker.queue)
+	}
+	m.closeMutex.Unlock()
+
+	go func() {
+		defer close(m.stopped)
+		m.wg.Wait()
+		// A worker that exited first may have been handed spilled tasks since.
+		for _, worker := range m.workers {
+			batch := newPendingBatch()
+			m.collect(worker, batch)
+			m.flush(batch)
+		}
+		if m.spill != nil {
+			m.spill.Close()
+		}
+		if closer, ok := m.deadLetters.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+
+	select {
+	case <-m.stopped:
+		lost := atomic.LoadUint64(&m.failed) - failed
+		return int(lost), nil
+	case <-ctx.Done():
+	}
+	close(m.aborted)
+	// Count the tasks discarded so far and those still pending, including
+	// any write in progress, whose outcome is unknown.
+	m.mutex.Lock()
+	lost := atomic.LoadUint64(&m.failed) - failed + atomic.LoadUint64(&m.abandoned) - abandoned
+	for _, count := range m.pending {
+		lost += uint64(count)
+	}
+	m.mutex.Unlock()
+	if m.spill != nil {
+		lost -= uint64(m.spill.len())
+	}
+	return int(lost), ctx.Err()
 }
 
-// Shutdown gracefully stops the manager and waits for pending tasks to complete.
-func (m *AsyncPersistenceManager) Shutdown() {
-	close(m.taskQueue)
-	m.wg.Wait()
+// Stopped returns a channel that is closed once Shutdown has been called and
+// the workers have exited, so that no write is in progress.
+func (m *AsyncPersistenceManager) Stopped() <-chan struct{} {
+	return m.stopped
 }
@@ -0,0 +1,560 @@
+// File: async_persistence_test.go
+
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cachefy/backends/inmemory"
+	"cachefy/repository"
+	"cachefy/serialization"
+)
+
+func TestWriteBehindCache(t *testing.T) {
+	repo := newMemoryRepository()
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{
+		QueueSize:     16,
+		RetryLimit:    3,
+		FlushInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	cache := NewWriteBehindCache(inmemory.NewRWMutexCache(5*time.Minute), repo, manager)
+
+	cache.Set("key1", "value1")
+	cache.Set("key2", "value2")
+	cache.Delete("key2")
+
+	// Test that the write is visible in memory before it is flushed
+	if val, err := cache.Get("key1"); err != nil || val != "value1" {
+		t.Errorf("Expected value1, got %v, error: %v", val, err)
+	}
+
+	// Test that a pending delete is not undone by read-through
+	if _, err := cache.Get("key2"); err != inmemory.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss for deleted key, got %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if entry, err := repo.Get("key1"); err != nil || entry.Value != "value1" {
+		t.Errorf("Expected key1 to be flushed, got %+v, error: %v", entry, err)
+	}
+	if _, err := repo.Get("key2"); err == nil {
+		t.Errorf("Expected key2 to be deleted from the repository")
+	}
+
+	// Test that Close flushes queued writes
+	cache.Set("key3", "value3")
+	cache.Close()
+	if entry, err := repo.Get("key3"); err != nil || entry.Value != "value3" {
+		t.Errorf("Expected key3 to be flushed on Close, got %+v, error: %v", entry, err)
+	}
+}
+
+func TestAsyncPersistenceCoalescing(t *testing.T) {
+	repo := newMemoryRepository()
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{
+		QueueSize:     1024,
+		RetryLimit:    3,
+		FlushInterval: time.Hour,
+		BatchSize:     100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 500; i++ {
+		manager.Enqueue(ctx, &repository.CacheEntry{Key: "hot", Value: i, ExpiresAt: repository.NeverExpires})
+	}
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: "deleted", Value: "value", ExpiresAt: repository.NeverExpires})
+	manager.EnqueueDelete(ctx, "deleted")
+	manager.Shutdown(context.Background())
+
+	if entry, err := repo.Get("hot"); err != nil || entry.Value != 499 {
+		t.Errorf("Expected last write to win, got %+v, error: %v", entry, err)
+	}
+	if _, err := repo.Get("deleted"); err == nil {
+		t.Errorf("Expected tombstone to delete the key")
+	}
+	if repo.batches != 1 {
+		t.Errorf("Expected a single batched flush, got %d", repo.batches)
+	}
+}
+
+// blockingRepository holds every WriteBatch call until release is closed.
+type blockingRepository struct {
+	*memoryRepository
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingRepository() *blockingRepository {
+	return &blockingRepository{
+		memoryRepository: newMemoryRepository(),
+		entered:          make(chan struct{}, 1),
+		release:          make(chan struct{}),
+	}
+}
+
+func (r *blockingRepository) WriteBatch(entries []*repository.CacheEntry, deletes []string) error {
+	select {
+	case r.entered <- struct{}{}:
+	default:
+	}
+	<-r.release
+	return r.memoryRepository.WriteBatch(entries, deletes)
+}
+
+// newStalledManager returns a manager whose worker is stuck in a flush and
+// whose single-slot queue holds the entry "queued".
+func newStalledManager(t *testing.T, config AsyncPersistenceConfig) (*AsyncPersistenceManager, *blockingRepository) {
+	repo := newBlockingRepository()
+	config.QueueSize = 1
+	config.BatchSize = 1
+	manager, err := NewAsyncPersistenceManager(repo, config)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	ctx := context.Background()
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: "flushing", Value: "value", ExpiresAt: repository.NeverExpires})
+	<-repo.entered
+	if result, err := manager.Enqueue(ctx, &repository.CacheEntry{Key: "queued", Value: "value", ExpiresAt: repository.NeverExpires}); result != Enqueued {
+		t.Fatalf("Expected Enqueued, got %v, error: %v", result, err)
+	}
+	return manager, repo
+}
+
+func TestAsyncPersistenceBackpressure(t *testing.T) {
+	ctx := context.Background()
+
+	// Test that block gives up when the context is done
+	manager, repo := newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureBlock})
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	result, err := manager.Enqueue(timeout, &repository.CacheEntry{Key: "late", Value: "value", ExpiresAt: repository.NeverExpires})
+	cancel()
+	if result != TimedOut || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected TimedOut, got %v, error: %v", result, err)
+	}
+	close(repo.release)
+	manager.Shutdown(context.Background())
+	if _, err := repo.Get("late"); err == nil {
+		t.Errorf("Expected timed out entry not to be persisted")
+	}
+
+	// Test that drop-newest discards the new task
+	manager, repo = newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureDropNewest})
+	if result, _ := manager.Enqueue(ctx, &repository.CacheEntry{Key: "newest", Value: "value", ExpiresAt: repository.NeverExpires}); result != DroppedNewest {
+		t.Errorf("Expected DroppedNewest, got %v", result)
+	}
+	if manager.Pending("newest") {
+		t.Errorf("Expected dropped task not to be pending")
+	}
+	close(repo.release)
+	manager.Shutdown(context.Background())
+	if _, err := repo.Get("queued"); err != nil {
+		t.Errorf("Expected queued entry to be persisted, error: %v", err)
+	}
+	if stats := manager.Stats(); stats.DroppedNewest != 1 {
+		t.Errorf("Expected one dropped task, got %+v", stats)
+	}
+
+	// Test that drop-oldest makes room for the new task
+	manager, repo = newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureDropOldest})
+	if result, _ := manager.Enqueue(ctx, &repository.CacheEntry{Key: "newest", Value: "value", ExpiresAt: repository.NeverExpires}); result != DroppedOldest {
+		t.Errorf("Expected DroppedOldest, got %v", result)
+	}
+	close(repo.release)
+	manager.Shutdown(context.Background())
+	if _, err := repo.Get("queued"); err == nil {
+		t.Errorf("Expected oldest entry to be dropped")
+	}
+	if _, err := repo.Get("newest"); err != nil {
+		t.Errorf("Expected newest entry to be persisted, error: %v", err)
+	}
+
+	// Test that spill writes overflow to disk and persists it later
+	overflow := filepath.Join(t.TempDir(), "overflow.jsonl")
+	manager, repo = newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureSpill, OverflowPath: overflow})
+	if result, err := manager.Enqueue(ctx, &repository.CacheEntry{Key: "spilled", Value: "old", ExpiresAt: repository.NeverExpires}); result != Spilled {
+		t.Errorf("Expected Spilled, got %v, error: %v", result, err)
+	}
+	if !manager.Pending("spilled") {
+		t.Errorf("Expected spilled task to be pending")
+	}
+	close(repo.release)
+	manager.Shutdown(context.Background())
+	if entry, err := repo.Get("spilled"); err != nil || entry.Value != "old" {
+		t.Errorf("Expected spilled entry to be persisted, got %+v, error: %v", entry, err)
+	}
+}
+
+func TestAsyncPersistenceSpillRecovery(t *testing.T) {
+	overflow := filepath.Join(t.TempDir(), "overflow.jsonl")
+	spill, err := openSpillFile(overflow, nil)
+	if err != nil {
+		t.Fatalf("Failed to open overflow file: %v", err)
+	}
+	spill.append(&persistenceTask{seq: 7, op: opSet, key: "key1", entry: &repository.CacheEntry{Key: "key1", Value: "old", ExpiresAt: repository.NeverExpires}})
+	spill.append(&persistenceTask{seq: 8, op: opSet, key: "key2", entry: &repository.CacheEntry{Key: "key2", Value: "value2", ExpiresAt: repository.NeverExpires}})
+	spill.Close()
+
+	// Test that tasks left over from a previous run are persisted
+	repo := newMemoryRepository()
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{
+		QueueSize:    16,
+		Backpressure: BackpressureSpill,
+		OverflowPath: overflow,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if entry, err := repo.Get("key2"); err != nil || entry.Value != "value2" || manager.Pending("key2") {
+		t.Errorf("Expected recovered entry before Shutdown, got %+v, error: %v", entry, err)
+	}
+
+	// Test that leftover tasks are older than new ones
+	manager.Enqueue(context.Background(), &repository.CacheEntry{Key: "key1", Value: "new", ExpiresAt: repository.NeverExpires})
+	manager.Shutdown(context.Background())
+
+	if entry, err := repo.Get("key1"); err != nil || entry.Value != "new" {
+		t.Errorf("Expected newest write to win, got %+v, error: %v", entry, err)
+	}
+	if entry, err := repo.Get("key2"); err != nil || entry.Value != "value2" {
+		t.Errorf("Expected recovered entry, got %+v, error: %v", entry, err)
+	}
+}
+
+// spillPoint is a struct value spilled in TestAsyncPersistenceSpillValueTypes.
+type spillPoint struct {
+	X, Y int
+}
+
+func TestAsyncPersistenceSpillValueTypes(t *testing.T) {
+	if err := serialization.Register("persistence.spillPoint", spillPoint{}); err != nil {
+		t.Fatalf("Failed to register type: %v", err)
+	}
+	values := map[string]interface{}{
+		"int":    42,
+		"struct": spillPoint{X: 1, Y: 2},
+		"bytes":  []byte("hi"),
+	}
+
+	// Test that spilled values come back as their original types
+	for _, serializer := range []serialization.Serializer{nil, &serialization.JSONSerializer{}} {
+		overflow := filepath.Join(t.TempDir(), "overflow.jsonl")
+		manager, repo := newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureSpill, OverflowPath: overflow, Serializer: serializer})
+		for key, value := range values {
+			if result, err := manager.Enqueue(context.Background(), &repository.CacheEntry{Key: key, Value: value, ExpiresAt: repository.NeverExpires}); result != Spilled {
+				t.Errorf("Expected Spilled, got %v, error: %v", result, err)
+			}
+		}
+		close(repo.release)
+		manager.Shutdown(context.Background())
+		for key, value := range values {
+			entry, err := repo.Get(key)
+			if err != nil || !reflect.DeepEqual(entry.Value, value) {
+				t.Errorf("Expected %#v for %s, got %+v, error: %v", value, key, entry, err)
+			}
+		}
+	}
+}
+
+// failingRepository fails every WriteBatch call while failing is set.
+type failingRepository struct {
+	*memoryRepository
+	failing atomic.Bool
+}
+
+func (r *failingRepository) WriteBatch(entries []*repository.CacheEntry, deletes []string) error {
+	if r.failing.Load() {
+		return errors.New("database unavailable")
+	}
+	return r.memoryRepository.WriteBatch(entries, deletes)
+}
+
+func (r *failingRepository) Clear() error {
+	if r.failing.Load() {
+		return errors.New("database unavailable")
+	}
+	return r.memoryRepository.Clear()
+}
+
+func TestAsyncPersistenceDeadLetters(t *testing.T) {
+	repo := &failingRepository{memoryRepository: newMemoryRepository()}
+	repo.failing.Store(true)
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{
+		QueueSize:   16,
+		RetryLimit:  1,
+		DeadLetters: NewRingDeadLetterStore(10),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Shutdown(context.Background())
+
+	ctx := context.Background()
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: "key1", Value: "value1", ExpiresAt: repository.NeverExpires})
+	time.Sleep(50 * time.Millisecond)
+
+	// Test that the failed write is captured with its error
+	letters, err := manager.DeadLetters()
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("Expected one dead letter, got %v, error: %v", letters, err)
+	}
+	if letters[0].Key != "key1" || letters[0].Attempts != 1 || letters[0].LastError != "database unavailable" {
+		t.Errorf("Unexpected dead letter %+v", letters[0])
+	}
+
+	// Test that a failed replay keeps the letter
+	if replayed, err := manager.ReplayDeadLetters(); err == nil || replayed != 0 {
+		t.Errorf("Expected replay to fail, got %d, error: %v", replayed, err)
+	}
+	if letters, _ := manager.DeadLetters(); len(letters) != 1 || letters[0].Attempts != 2 {
+		t.Errorf("Expected dead letter with 2 attempts, got %v", letters)
+	}
+
+	// Test that replay persists the letter once the database recovers
+	repo.failing.Store(false)
+	if replayed, err := manager.ReplayDeadLetters(); err != nil || replayed != 1 {
+		t.Errorf("Expected one replayed letter, got %d, error: %v", replayed, err)
+	}
+	if entry, err := repo.Get("key1"); err != nil || entry.Value != "value1" {
+		t.Errorf("Expected replayed entry, got %+v, error: %v", entry, err)
+	}
+	if letters, _ := manager.DeadLetters(); len(letters) != 0 {
+		t.Errorf("Expected no dead letters after replay, got %v", letters)
+	}
+
+	// Test that a successful write supersedes an earlier letter
+	repo.failing.Store(true)
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: "key2", Value: "old", ExpiresAt: repository.NeverExpires})
+	time.Sleep(50 * time.Millisecond)
+	repo.failing.Store(false)
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: "key2", Value: "new", ExpiresAt: repository.NeverExpires})
+	time.Sleep(50 * time.Millisecond)
+	if letters, _ := manager.DeadLetters(); len(letters) != 0 {
+		t.Errorf("Expected superseded letter to be removed, got %v", letters)
+	}
+
+	// Test that a failed clear is kept by replay and can be dismissed
+	repo.failing.Store(true)
+	manager.EnqueueClear()
+	time.Sleep(50 * time.Millisecond)
+	repo.failing.Store(false)
+	if replayed, err := manager.ReplayDeadLetters(); err != nil || replayed != 0 {
+		t.Errorf("Expected no replayed letters, got %d, error: %v", replayed, err)
+	}
+	if letters, _ := manager.DeadLetters(); len(letters) != 1 || letters[0].Op != DeadLetterClear {
+		t.Errorf("Expected the clear letter, got %v", letters)
+	}
+	if err := manager.DismissDeadLetters(); err != nil {
+		t.Errorf("Expected DismissDeadLetters to succeed, error: %v", err)
+	}
+	if letters, _ := manager.DeadLetters(); len(letters) != 0 {
+		t.Errorf("Expected no dead letters after dismissing, got %v", letters)
+	}
+}
+
+// slowKeyRepository holds WriteBatch calls that include slowKey until release
+// is closed.
+type slowKeyRepository struct {
+	*memoryRepository
+	slowKey string
+	release chan struct{}
+}
+
+func (r *slowKeyRepository) WriteBatch(entries []*repository.CacheEntry, deletes []string) error {
+	for _, entry := range entries {
+		if entry.Key == r.slowKey {
+			<-r.release
+		}
+	}
+	return r.memoryRepository.WriteBatch(entries, deletes)
+}
+
+func TestAsyncPersistenceWorkers(t *testing.T) {
+	repo := &slowKeyRepository{memoryRepository: newMemoryRepository(), slowKey: "slow", release: make(chan struct{})}
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{
+		QueueSize: 1024,
+		Workers:   4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	// Test that a stalled key does not hold up keys owned by other workers
+	ctx := context.Background()
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: "slow", Value: "value", ExpiresAt: repository.NeverExpires})
+	var fast string
+	for i := 0; fast == ""; i++ {
+		if key := fmt.Sprintf("key%d", i); manager.workerFor(key) != manager.workerFor("slow") {
+			fast = key
+		}
+	}
+	manager.Enqueue(ctx, &repository.CacheEntry{Key: fast, Value: "value", ExpiresAt: repository.NeverExpires})
+	time.Sleep(50 * time.Millisecond)
+	if _, err := repo.Get(fast); err != nil {
+		t.Errorf("Expected %s to be persisted while slow is stalled, error: %v", fast, err)
+	}
+
+	close(repo.release)
+
+	// Test that writes to each key are applied in order
+	for i := 0; i < 100; i++ {
+		for k := 0; k < 20; k++ {
+			manager.Enqueue(ctx, &repository.CacheEntry{Key: fmt.Sprintf("key%d", k), Value: i, ExpiresAt: repository.NeverExpires})
+		}
+	}
+	manager.Shutdown(context.Background())
+	for k := 0; k < 20; k++ {
+		if entry, err := repo.Get(fmt.Sprintf("key%d", k)); err != nil || entry.Value != 99 {
+			t.Errorf("Expected last write to key%d to win, got %+v, error: %v", k, entry, err)
+		}
+	}
+}
+
+func TestAsyncPersistenceSpillWorkers(t *testing.T) {
+	repo := newMemoryRepository()
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{
+		QueueSize:    16,
+		Workers:      2,
+		Backpressure: BackpressureSpill,
+		OverflowPath: filepath.Join(t.TempDir(), "overflow.jsonl"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Shutdown(context.Background())
+
+	var idle string
+	for i := 0; idle == ""; i++ {
+		if key := fmt.Sprintf("key%d", i); manager.workerFor(key) != manager.workerFor("busy") {
+			idle = key
+		}
+	}
+
+	// Test that a spilled task picked up by another worker is written by an
+	// idle owner without further traffic
+	time.Sleep(20 * time.Millisecond)
+	task := manager.prepare(opSet, idle, &repository.CacheEntry{Key: idle, Value: "spilled", ExpiresAt: repository.NeverExpires})
+	if err := manager.spill.append(task); err != nil {
+		t.Fatalf("Failed to spill task: %v", err)
+	}
+	manager.Enqueue(context.Background(), &repository.CacheEntry{Key: "busy", Value: "value", ExpiresAt: repository.NeverExpires})
+	time.Sleep(50 * time.Millisecond)
+	if entry, err := repo.Get(idle); err != nil || entry.Value != "spilled" || manager.Pending(idle) {
+		t.Errorf("Expected spilled entry before Shutdown, got %+v, error: %v", entry, err)
+	}
+}
+
+func TestAsyncPersistenceLateTask(t *testing.T) {
+	repo := newMemoryRepository()
+	manager, err := NewAsyncPersistenceManager(repo, AsyncPersistenceConfig{QueueSize: 16, Workers: 2})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Shutdown(context.Background())
+
+	newTask := func(value string) *persistenceTask {
+		task := &persistenceTask{op: opSet, key: "key", entry: &repository.CacheEntry{Key: "key", Value: value, ExpiresAt: repository.NeverExpires}}
+		manager.track(task, 1)
+		task.seq = manager.nextSeq()
+		return task
+	}
+	old, newer := newTask("old"), newTask("new")
+
+	// Test that an older task reaching its worker after a newer one was
+	// flushed, as a spilled task handed over by another worker can, is discarded
+	for _, task := range []*persistenceTask{newer, old} {
+		batch := newPendingBatch()
+		manager.coalesce(batch, task)
+		manager.flush(batch)
+	}
+	if entry, err := repo.Get("key"); err != nil || entry.Value != "new" {
+		t.Errorf("Expected the newer write to win, got %+v, error: %v", entry, err)
+	}
+	if manager.Pending("key") {
+		t.Errorf("Expected no pending writes for key")
+	}
+}
+
+func TestAsyncPersistenceShutdown(t *testing.T) {
+	manager, repo := newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureBlock})
+
+	// Test that Shutdown returns at the deadline, without waiting for the
+	// write in progress, and counts it as lost along with the queued one
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	lost, err := manager.Shutdown(ctx)
+	if lost != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected 2 lost writes and DeadlineExceeded, got %d, error: %v", lost, err)
+	}
+	select {
+	case <-manager.Stopped():
+		t.Errorf("Expected the write in progress to still be running")
+	default:
+	}
+
+	// Test that the write in progress finishes in the background
+	close(repo.release)
+	<-manager.Stopped()
+	if _, err := repo.Get("flushing"); err != nil {
+		t.Errorf("Expected the write in progress to complete, error: %v", err)
+	}
+	if _, err := repo.Get("queued"); err == nil {
+		t.Errorf("Expected the queued write to be discarded")
+	}
+
+	// Test that writes after shutdown are rejected instead of panicking
+	result, err := manager.Enqueue(context.Background(), &repository.CacheEntry{Key: "late", Value: "value", ExpiresAt: repository.NeverExpires})
+	if result != Rejected || !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("Expected Rejected with ErrManagerClosed, got %v, error: %v", result, err)
+	}
+	if err := manager.EnqueueClear(); !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("Expected ErrManagerClosed for clear, got %v", err)
+	}
+	if _, err := manager.Shutdown(context.Background()); !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("Expected ErrManagerClosed for a second Shutdown, got %v", err)
+	}
+}
+
+func TestAsyncPersistenceShutdownUnblocksProducers(t *testing.T) {
+	manager, repo := newStalledManager(t, AsyncPersistenceConfig{Backpressure: BackpressureBlock})
+
+	// Test that a producer waiting for room is released by Shutdown
+	errs := make(chan error, 1)
+	go func() {
+		_, err := manager.Enqueue(context.Background(), &repository.CacheEntry{Key: "waiting", Value: "value", ExpiresAt: repository.NeverExpires})
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan int, 1)
+	go func() {
+		lost, _ := manager.Shutdown(context.Background())
+		shutdown <- lost
+	}()
+	if err := <-errs; !errors.Is(err, ErrManagerClosed) {
+		t.Errorf("Expected ErrManagerClosed for the waiting producer, got %v", err)
+	}
+
+	// Test that a drained shutdown loses nothing
+	close(repo.release)
+	if lost := <-shutdown; lost != 0 {
+		t.Errorf("Expected no lost writes, got %d", lost)
+	}
+	if _, err := repo.Get("queued"); err != nil {
+		t.Errorf("Expected queued entry to be persisted, error: %v", err)
+	}
+}
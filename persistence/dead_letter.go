@@ -0,0 +1,433 @@
+// File: dead_letter.go
+
+package persistence
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cachefy/repository"
+	"cachefy/serialization"
+)
+
+// DeadLetterOp is the kind of write recorded in a DeadLetter.
+type DeadLetterOp string
+
+const (
+	DeadLetterSet    DeadLetterOp = "set"
+	DeadLetterDelete DeadLetterOp = "delete"
+	DeadLetterClear  DeadLetterOp = "clear"
+)
+
+// DeadLetter is a write-behind write that exhausted its persistence retries.
+type DeadLetter struct {
+	Op        DeadLetterOp           `json:"op"`
+	Key       string                 `json:"key"`             // Empty for DeadLetterClear
+	Entry     *repository.CacheEntry `json:"entry,omitempty"` // Set for DeadLetterSet
+	LastError string                 `json:"last_error"`
+	Attempts  int                    `json:"attempts"`
+	FailedAt  time.Time              `json:"failed_at"`
+}
+
+// DeadLetterStore records writes that could not be persisted. A store holds
+// at most one letter per key, and one clear letter apart from those: a later
+// failure replaces the earlier letter.
+type DeadLetterStore interface {
+	// Put records letters.
+	Put(letters []*DeadLetter) error
+	// List returns all recorded letters.
+	List() ([]*DeadLetter, error)
+	// Remove forgets the letters for keys.
+	Remove(keys []string) error
+	// Reset forgets all letters.
+	Reset() error
+}
+
+const defaultDeadLetterCapacity = 1000
+
+// deadLetterSlot returns the slot a store keeps letter in. Clear letters have
+// a slot of their own, so they do not collide with a letter for the key "".
+func deadLetterSlot(letter *DeadLetter) string {
+	if letter.Op == DeadLetterClear {
+		return "clear"
+	}
+	return keySlot(letter.Key)
+}
+
+// keySlot returns the slot of the letter for key.
+func keySlot(key string) string {
+	return "key:" + key
+}
+
+// deadLetterRecord is the on-disk form of a DeadLetter. The entry's value is
+// encoded with the store's serializer and tagged with its type, so that a
+// replayed write restores the value as its original type.
+type deadLetterRecord struct {
+	Op        DeadLetterOp  `json:"op"`
+	Key       string        `json:"key"`
+	Encoded   *encodedEntry `json:"encoded,omitempty"`
+	LastError string        `json:"last_error"`
+	Attempts  int           `json:"attempts"`
+	FailedAt  time.Time     `json:"failed_at"`
+}
+
+func encodeDeadLetter(serializer serialization.Serializer, letter *DeadLetter) (*deadLetterRecord, error) {
+	record := &deadLetterRecord{
+		Op:        letter.Op,
+		Key:       letter.Key,
+		LastError: letter.LastError,
+		Attempts:  letter.Attempts,
+		FailedAt:  letter.FailedAt,
+	}
+	if letter.Entry != nil {
+		encoded, err := encodeEntry(serializer, letter.Entry)
+		if err != nil {
+			return nil, fmt.Errorf("encoding dead letter for key %q: %w", letter.Key, err)
+		}
+		record.Encoded = encoded
+	}
+	return record, nil
+}
+
+func (r *deadLetterRecord) decode(serializer serialization.Serializer) (*DeadLetter, error) {
+	letter := &DeadLetter{
+		Op:        r.Op,
+		Key:       r.Key,
+		LastError: r.LastError,
+		Attempts:  r.Attempts,
+		FailedAt:  r.FailedAt,
+	}
+	if r.Encoded != nil {
+		entry, err := r.Encoded.decode(serializer)
+		if err != nil {
+			return nil, fmt.Errorf("decoding dead letter for key %q: %w", r.Key, err)
+		}
+		letter.Entry = entry
+	}
+	return letter, nil
+}
+
+// RingDeadLetterStore keeps the most recent letters in memory, discarding the
+// oldest once capacity is reached.
+type RingDeadLetterStore struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List
+	letters  map[string]*list.Element
+}
+
+// NewRingDeadLetterStore creates a RingDeadLetterStore holding up to capacity
+// letters. A capacity <= 0 selects 1000.
+func NewRingDeadLetterStore(capacity int) *RingDeadLetterStore {
+	if capacity <= 0 {
+		capacity = defaultDeadLetterCapacity
+	}
+	return &RingDeadLetterStore{
+		capacity: capacity,
+		order:    list.New(),
+		letters:  make(map[string]*list.Element),
+	}
+}
+
+func (s *RingDeadLetterStore) Put(letters []*DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, letter := range letters {
+		slot := deadLetterSlot(letter)
+		if elem, ok := s.letters[slot]; ok {
+			s.order.Remove(elem)
+		}
+		s.letters[slot] = s.order.PushBack(letter)
+		if s.order.Len() > s.capacity {
+			oldest := s.order.Remove(s.order.Front()).(*DeadLetter)
+			delete(s.letters, deadLetterSlot(oldest))
+		}
+	}
+	return nil
+}
+
+func (s *RingDeadLetterStore) List() ([]*DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	letters := make([]*DeadLetter, 0, s.order.Len())
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		letters = append(letters, elem.Value.(*DeadLetter))
+	}
+	return letters, nil
+}
+
+func (s *RingDeadLetterStore) Remove(keys []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := s.letters[keySlot(key)]; ok {
+			s.order.Remove(elem)
+			delete(s.letters, keySlot(key))
+		}
+	}
+	return nil
+}
+
+func (s *RingDeadLetterStore) Reset() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.order.Init()
+	s.letters = make(map[string]*list.Element)
+	return nil
+}
+
+// FileDeadLetterStore keeps letters in a JSON file so they survive restarts.
+// The file is rewritten atomically on every change.
+type FileDeadLetterStore struct {
+	mutex      sync.Mutex
+	path       string
+	serializer serialization.Serializer
+	letters    map[string]*DeadLetter
+	records    map[string]*deadLetterRecord
+}
+
+// NewFileDeadLetterStore opens the store at path, loading any letters
+// already recorded there. Entry values are encoded with serializer; a nil
+// serializer selects serialization.BlobSerializer.
+func NewFileDeadLetterStore(path string, serializer serialization.Serializer) (*FileDeadLetterStore, error) {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
+	store := &FileDeadLetterStore{
+		path:       path,
+		serializer: serializer,
+		letters:    make(map[string]*DeadLetter),
+		records:    make(map[string]*deadLetterRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []*deadLetterRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("reading dead letters from %s: %w", path, err)
+	}
+	for _, record := range records {
+		letter, err := record.decode(serializer)
+		if err != nil {
+			return nil, fmt.Errorf("reading dead letters from %s: %w", path, err)
+		}
+		slot := deadLetterSlot(letter)
+		store.letters[slot] = letter
+		store.records[slot] = record
+	}
+	return store, nil
+}
+
+func (s *FileDeadLetterStore) Put(letters []*DeadLetter) error {
+	records := make([]*deadLetterRecord, 0, len(letters))
+	for _, letter := range letters {
+		record, err := encodeDeadLetter(s.serializer, letter)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, letter := range letters {
+		slot := deadLetterSlot(letter)
+		s.letters[slot] = letter
+		s.records[slot] = records[i]
+	}
+	return s.save()
+}
+
+func (s *FileDeadLetterStore) List() ([]*DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	letters := make([]*DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (s *FileDeadLetterStore) Remove(keys []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := false
+	for _, key := range keys {
+		if _, ok := s.letters[keySlot(key)]; ok {
+			delete(s.letters, keySlot(key))
+			delete(s.records, keySlot(key))
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *FileDeadLetterStore) Reset() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.letters) == 0 {
+		return nil
+	}
+	s.letters = make(map[string]*DeadLetter)
+	s.records = make(map[string]*deadLetterRecord)
+	return s.save()
+}
+
+// save writes all letters to a temporary file, fsyncs it and renames it over
+// the store. The caller must hold the mutex.
+func (s *FileDeadLetterStore) save() error {
+	records := make([]*deadLetterRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// RepositoryDeadLetterStore keeps letters in a Repository, one JSON-encoded
+// entry per slot. The repository must be separate from the one the cache
+// persists to, such as a second SQLite database.
+type RepositoryDeadLetterStore struct {
+	repo       repository.Repository
+	serializer serialization.Serializer
+}
+
+// NewRepositoryDeadLetterStore creates a store backed by repo. Entry values
+// are encoded with serializer; a nil serializer selects
+// serialization.BlobSerializer.
+func NewRepositoryDeadLetterStore(repo repository.Repository, serializer serialization.Serializer) *RepositoryDeadLetterStore {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
+	return &RepositoryDeadLetterStore{repo: repo, serializer: serializer}
+}
+
+// entry returns the repository entry that holds letter.
+func (s *RepositoryDeadLetterStore) entry(letter *DeadLetter) (*repository.CacheEntry, error) {
+	record, err := encodeDeadLetter(s.serializer, letter)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.CacheEntry{
+		Key:       deadLetterSlot(letter),
+		Value:     string(data),
+		ExpiresAt: repository.NeverExpires,
+	}, nil
+}
+
+func (s *RepositoryDeadLetterStore) Put(letters []*DeadLetter) error {
+	entries := make([]*repository.CacheEntry, 0, len(letters))
+	for _, letter := range letters {
+		entry, err := s.entry(letter)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	return s.repo.WriteBatch(entries, nil)
+}
+
+func (s *RepositoryDeadLetterStore) List() ([]*DeadLetter, error) {
+	const pageSize = 100
+
+	var letters []*DeadLetter
+	for offset := 0; ; offset += pageSize {
+		entries, err := s.repo.Paginate(offset, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			var data []byte
+			switch value := entry.Value.(type) {
+			case string:
+				data = []byte(value)
+			case []byte:
+				data = value
+			default:
+				return nil, fmt.Errorf("unexpected dead letter value %T for key %q", entry.Value, entry.Key)
+			}
+			var record deadLetterRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return nil, err
+			}
+			letter, err := record.decode(s.serializer)
+			if err != nil {
+				return nil, err
+			}
+			letters = append(letters, letter)
+		}
+		if len(entries) < pageSize {
+			return letters, nil
+		}
+	}
+}
+
+func (s *RepositoryDeadLetterStore) Remove(keys []string) error {
+	slots := make([]string, 0, len(keys))
+	for _, key := range keys {
+		slots = append(slots, keySlot(key))
+	}
+	return s.repo.WriteBatch(nil, slots)
+}
+
+func (s *RepositoryDeadLetterStore) Reset() error {
+	return s.repo.Clear()
+}
+
+// Close closes the underlying repository.
+func (s *RepositoryDeadLetterStore) Close() error {
+	return s.repo.Close()
+}
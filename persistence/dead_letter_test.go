@@ -0,0 +1,144 @@
+// File: dead_letter_test.go
+
+package persistence
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cachefy/repository"
+	"cachefy/serialization"
+)
+
+func TestRingDeadLetterStore(t *testing.T) {
+	store := NewRingDeadLetterStore(2)
+	store.Put([]*DeadLetter{
+		{Op: DeadLetterSet, Key: "key1", Entry: &repository.CacheEntry{Key: "key1", Value: "value1", ExpiresAt: repository.NeverExpires}},
+		{Op: DeadLetterDelete, Key: "key2"},
+	})
+
+	// Test that a later failure replaces the letter for the same key
+	store.Put([]*DeadLetter{{Op: DeadLetterDelete, Key: "key1"}})
+	letters, _ := store.List()
+	if len(letters) != 2 || letters[1].Key != "key1" || letters[1].Op != DeadLetterDelete {
+		t.Errorf("Expected key1 to be replaced, got %v", letters)
+	}
+
+	// Test that the oldest letter is discarded at capacity
+	store.Put([]*DeadLetter{{Op: DeadLetterDelete, Key: "key3"}})
+	letters, _ = store.List()
+	if len(letters) != 2 || letters[0].Key != "key1" || letters[1].Key != "key3" {
+		t.Errorf("Expected key2 to be discarded, got %v", letters)
+	}
+
+	store.Remove([]string{"key1"})
+	if letters, _ := store.List(); len(letters) != 1 {
+		t.Errorf("Expected one letter after Remove, got %v", letters)
+	}
+	store.Reset()
+	if letters, _ := store.List(); len(letters) != 0 {
+		t.Errorf("Expected no letters after Reset, got %v", letters)
+	}
+}
+
+func TestFileDeadLetterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	store, err := NewFileDeadLetterStore(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	store.Put([]*DeadLetter{
+		{Op: DeadLetterSet, Key: "key1", Entry: &repository.CacheEntry{Key: "key1", Value: "value1", ExpiresAt: repository.NeverExpires}, LastError: "timeout", Attempts: 3},
+		{Op: DeadLetterDelete, Key: "key2"},
+	})
+	store.Remove([]string{"key2"})
+
+	// Test that letters survive reopening the store
+	reopened, err := NewFileDeadLetterStore(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	letters, _ := reopened.List()
+	if len(letters) != 1 || letters[0].Key != "key1" || letters[0].Attempts != 3 || letters[0].Entry.Value != "value1" {
+		t.Errorf("Expected key1 letter after reopening, got %v", letters)
+	}
+}
+
+// deadLetterPoint is a struct value recorded in the dead-letter store tests.
+type deadLetterPoint struct {
+	X, Y int
+}
+
+// deadLetterValues returns set letters for values of several types, a clear
+// letter and a letter for the key "".
+func deadLetterValues(t *testing.T) map[string]interface{} {
+	if err := serialization.Register("persistence.deadLetterPoint", deadLetterPoint{}); err != nil {
+		t.Fatalf("Failed to register type: %v", err)
+	}
+	return map[string]interface{}{
+		"int":    42,
+		"struct": deadLetterPoint{X: 1, Y: 2},
+		"bytes":  []byte("hi"),
+		"":       "empty key",
+	}
+}
+
+func putDeadLetterValues(t *testing.T, store DeadLetterStore, values map[string]interface{}) {
+	letters := []*DeadLetter{{Op: DeadLetterClear}}
+	for key, value := range values {
+		letters = append(letters, &DeadLetter{Op: DeadLetterSet, Key: key, Entry: &repository.CacheEntry{Key: key, Value: value, ExpiresAt: repository.NeverExpires}})
+	}
+	if err := store.Put(letters); err != nil {
+		t.Fatalf("Failed to put letters: %v", err)
+	}
+}
+
+func checkDeadLetterValues(t *testing.T, store DeadLetterStore, values map[string]interface{}) {
+	letters, err := store.List()
+	if err != nil || len(letters) != len(values)+1 {
+		t.Fatalf("Expected %d letters, got %v, error: %v", len(values)+1, letters, err)
+	}
+	for _, letter := range letters {
+		if letter.Op == DeadLetterClear {
+			continue
+		}
+		if value := values[letter.Key]; letter.Entry == nil || !reflect.DeepEqual(letter.Entry.Value, value) {
+			t.Errorf("Expected %#v for %q, got %+v", value, letter.Key, letter.Entry)
+		}
+	}
+}
+
+func TestDeadLetterStoreValueTypes(t *testing.T) {
+	values := deadLetterValues(t)
+
+	// Test that the ring store keeps a clear letter apart from the key ""
+	ring := NewRingDeadLetterStore(10)
+	putDeadLetterValues(t, ring, values)
+	checkDeadLetterValues(t, ring, values)
+
+	// Test that the file store restores values as their original types
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	file, err := NewFileDeadLetterStore(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	putDeadLetterValues(t, file, values)
+	reopened, err := NewFileDeadLetterStore(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	checkDeadLetterValues(t, reopened, values)
+
+	// Test that the repository store restores values as their original types
+	repo := newMemoryRepository()
+	store := NewRepositoryDeadLetterStore(repo, nil)
+	putDeadLetterValues(t, store, values)
+	checkDeadLetterValues(t, store, values)
+
+	// Test that removing the letter for "" keeps the clear letter
+	store.Remove([]string{""})
+	if letters, _ := store.List(); len(letters) != len(values) {
+		t.Errorf("Expected %d letters after Remove, got %v", len(values), letters)
+	}
+}
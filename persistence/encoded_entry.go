@@ -0,0 +1,34 @@
+// File: encoded_entry.go
+
+package persistence
+
+import (
+	"cachefy/repository"
+	"cachefy/serialization"
+)
+
+// encodedEntry is the on-disk form of a CacheEntry kept outside the
+// repository. Its value is encoded with repository.EncodeValue, so it is
+// restored as its original type rather than as a JSON type.
+type encodedEntry struct {
+	Key       string `json:"key"`
+	Tag       string `json:"tag,omitempty"`
+	Value     []byte `json:"value"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func encodeEntry(serializer serialization.Serializer, entry *repository.CacheEntry) (*encodedEntry, error) {
+	tag, data, err := repository.EncodeValue(serializer, entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &encodedEntry{Key: entry.Key, Tag: tag, Value: data, ExpiresAt: entry.ExpiresAt}, nil
+}
+
+func (e *encodedEntry) decode(serializer serialization.Serializer) (*repository.CacheEntry, error) {
+	value, err := repository.DecodeValue(serializer, e.Key, e.Tag, e.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.CacheEntry{Key: e.Key, Value: value, ExpiresAt: e.ExpiresAt}, nil
+}
@@ -0,0 +1,51 @@
+// File: key_locks.go
+
+package persistence
+
+import "sync"
+
+// keyLocks hands out one mutex per key, so operations on a key are ordered
+// without blocking operations on other keys. A key's mutex is kept only
+// while it is held or waited for.
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the function that unlocks it. The returned
+// function may be called more than once; calls after the first do nothing.
+func (l *keyLocks) lock(key string) func() {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	unlocked := false
+	return func() {
+		if unlocked {
+			return
+		}
+		unlocked = true
+		lock.Unlock()
+
+		l.mutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mutex.Unlock()
+	}
+}
@@ -3,19 +3,36 @@
 package persistence
 
 import (
+	"cachefy/backends/inmemory"
 	"cachefy/interfaces"
 	"cachefy/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"sync"
+	"time"
 )
 
+// ErrWriteDropped is returned in write-behind mode when a write was applied to
+// the cache but discarded by the persistence queue's backpressure policy.
+var ErrWriteDropped = errors.New("write-behind queue full, write not persisted")
+
 // PersistentCache is a cache that wraps another Cache and persists data using a Repository.
 type PersistentCache struct {
 	cache interfaces.Cache
 	repo  repository.Repository
-	mutex sync.Mutex
+	async *AsyncPersistenceManager
+
+	// keys orders operations on the same key. Operations on a key hold
+	// clearMutex shared, so Clear and WarmUp, which take it exclusively,
+	// never interleave with them.
+	keys       keyLocks
+	clearMutex sync.RWMutex
 }
 
-// NewPersistentCache creates a new PersistentCache.
+// NewPersistentCache creates a new PersistentCache that writes through to the
+// repository synchronously. Close closes both cache and repo.
 func NewPersistentCache(cache interfaces.Cache, repo repository.Repository) *PersistentCache {
 	return &PersistentCache{
 		cache: cache,
@@ -23,52 +40,349 @@ func NewPersistentCache(cache interfaces.Cache, repo repository.Repository) *Per
 	}
 }
 
-// Set adds or updates a cache entry and persists it.
+// NewWriteBehindCache creates a PersistentCache that returns as soon as the
+// wrapped cache is updated and leaves repository writes and deletes to
+// manager, which must write to repo. Close shuts the manager down before
+// closing repo.
+func NewWriteBehindCache(cache interfaces.Cache, repo repository.Repository, manager *AsyncPersistenceManager) *PersistentCache {
+	return &PersistentCache{
+		cache: cache,
+		repo:  repo,
+		async: manager,
+	}
+}
+
+// Set adds or updates a cache entry and persists it. In write-behind mode it
+// returns ErrWriteDropped if the entry was cached but will not be persisted.
 func (p *PersistentCache) Set(key string, value interface{}) error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	unlock := p.lockKey(key)
+	defer unlock()
 
 	err := p.cache.Set(key, value)
 	if err != nil {
 		return err
 	}
 
-	// Persist the entry
+	return p.persist(key, value, unlock)
+}
+
+// SetWithTTL adds or updates a cache entry with its own lifetime and persists it.
+func (p *PersistentCache) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	unlock := p.lockKey(key)
+	defer unlock()
+
+	err := p.cache.SetWithTTL(key, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	return p.persist(key, value, unlock)
+}
+
+// TTL returns the remaining lifetime of key in the cache.
+func (p *PersistentCache) TTL(key string) (time.Duration, error) {
+	return p.cache.TTL(key)
+}
+
+// Touch resets the lifetime of an existing key and persists the new expiry.
+func (p *PersistentCache) Touch(key string, ttl time.Duration) error {
+	unlock := p.lockKey(key)
+	defer unlock()
+
+	err := p.cache.Touch(key, ttl)
+	if err != nil {
+		return err
+	}
+
+	return p.persistCurrent(key, unlock)
+}
+
+// Persist removes the expiry of an existing key in the cache and the repository.
+func (p *PersistentCache) Persist(key string) error {
+	unlock := p.lockKey(key)
+	defer unlock()
+
+	err := p.cache.Persist(key)
+	if err != nil {
+		return err
+	}
+
+	return p.persistCurrent(key, unlock)
+}
+
+// lockKey locks key against other operations on it and against Clear and
+// WarmUp, and returns the function that unlocks it. The function may be
+// called more than once.
+func (p *PersistentCache) lockKey(key string) func() {
+	p.clearMutex.RLock()
+	unlockKey := p.keys.lock(key)
+	unlocked := false
+	return func() {
+		if unlocked {
+			return
+		}
+		unlocked = true
+		unlockKey()
+		p.clearMutex.RUnlock()
+	}
+}
+
+// persist writes value to the repository with the expiry key has in the
+// wrapped cache. The caller must hold the lock of key, which persist releases
+// with unlock before waiting for room in the write-behind queue.
+func (p *PersistentCache) persist(key string, value interface{}, unlock func()) error {
+	ttl, err := p.cache.TTL(key)
+	if err != nil {
+		return err
+	}
+
 	entry := &repository.CacheEntry{
 		Key:       key,
 		Value:     value,
-		ExpiresAt: 0, // TTL not implemented for persistence
+		ExpiresAt: repository.ExpiresAtFor(ttl),
+	}
+	if p.async != nil {
+		task := p.async.prepare(opSet, key, entry)
+		unlock()
+		return p.enqueue(task)
 	}
 	return p.repo.Set(entry)
 }
 
-// Get retrieves a value from the cache.
+// enqueue submits a prepared task to the write-behind manager, waiting at most
+// the manager's EnqueueTimeout for room, and reports a discarded task as an
+// error.
+func (p *PersistentCache) enqueue(task *persistenceTask) error {
+	ctx := context.Background()
+	if p.async.enqueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.async.enqueueTimeout)
+		defer cancel()
+	}
+
+	result, err := p.async.submit(ctx, task)
+	if result != DroppedNewest && result != TimedOut && result != Rejected {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWriteDropped, err)
+	}
+	return ErrWriteDropped
+}
+
+// persistCurrent writes the value key has in the wrapped cache to the
+// repository. The caller must hold the lock of key; see persist.
+func (p *PersistentCache) persistCurrent(key string, unlock func()) error {
+	value, err := p.cache.Get(key)
+	if err != nil {
+		return err
+	}
+	return p.persist(key, value, unlock)
+}
+
+// Get retrieves a value from the cache. On a cache miss it reads the entry from
+// the repository and repopulates the cache with the entry's remaining lifetime.
 func (p *PersistentCache) Get(key string) (interface{}, error) {
-	return p.cache.Get(key)
+	value, err := p.cache.Get(key)
+	if !errors.Is(err, inmemory.ErrCacheMiss) {
+		return value, err
+	}
+	missErr := err
+
+	// Hold the lock of key so a concurrent Set cannot be overwritten by the
+	// older persisted value. Only operations on the same key wait for the read.
+	unlock := p.lockKey(key)
+	defer unlock()
+
+	if value, err := p.cache.Get(key); !errors.Is(err, inmemory.ErrCacheMiss) {
+		return value, err
+	}
+	// With write-behind, queued writes for key are newer than the repository.
+	if p.async != nil && p.async.Pending(key) {
+		return nil, missErr
+	}
+
+	entry, err := p.repo.Get(key)
+	if errors.Is(err, repository.ErrKeyNotFound) || errors.Is(err, repository.ErrKeyExpired) {
+		return nil, missErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	ttl, ok := cacheTTL(entry, time.Now())
+	if !ok {
+		return nil, missErr
+	}
+	if err := p.cache.SetWithTTL(key, entry.Value, ttl); err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// cacheTTL converts the expiry of a repository entry into a lifetime for
+// SetWithTTL, where zero means no expiry. It returns false if the entry has expired.
+func cacheTTL(entry *repository.CacheEntry, now time.Time) (time.Duration, bool) {
+	if entry.ExpiresAt == repository.NeverExpires {
+		return 0, true
+	}
+	ttl := time.Unix(entry.ExpiresAt, 0).Sub(now)
+	return ttl, ttl > 0
+}
+
+// boundedCache is implemented by in-memory backends with a capacity limit.
+type boundedCache interface {
+	Capacity() int
+	HasCapacityFor(key string) bool
+}
+
+// WarmUp hydrates the wrapped cache from the repository, streaming entries in
+// batches of batchSize and skipping expired ones. If the wrapped cache is
+// bounded, entries that would evict already loaded ones are skipped and loading
+// stops once the cache is full. It returns the number of entries loaded.
+func (p *PersistentCache) WarmUp(batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("batchSize must be greater than zero")
+	}
+
+	p.clearMutex.Lock()
+	defer p.clearMutex.Unlock()
+
+	bounded, isBounded := p.cache.(boundedCache)
+	isBounded = isBounded && bounded.Capacity() > 0
+	loaded := 0
+	for offset := 0; ; offset += batchSize {
+		entries, err := p.repo.Paginate(offset, batchSize)
+		if err != nil {
+			return loaded, err
+		}
+
+		now := time.Now()
+		for _, entry := range entries {
+			if isBounded {
+				if loaded >= bounded.Capacity() {
+					return loaded, nil
+				}
+				if !bounded.HasCapacityFor(entry.Key) {
+					continue
+				}
+			}
+			ttl, ok := cacheTTL(entry, now)
+			if !ok {
+				continue
+			}
+			if err := p.cache.SetWithTTL(entry.Key, entry.Value, ttl); err != nil {
+				return loaded, err
+			}
+			loaded++
+		}
+
+		if len(entries) < batchSize {
+			return loaded, nil
+		}
+	}
 }
 
 // Delete removes a value from the cache and the repository.
 func (p *PersistentCache) Delete(key string) error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	unlock := p.lockKey(key)
+	defer unlock()
 
 	err := p.cache.Delete(key)
 	if err != nil {
 		return err
 	}
 
+	if p.async != nil {
+		task := p.async.prepare(opDelete, key, nil)
+		unlock()
+		return p.enqueue(task)
+	}
 	return p.repo.Delete(key)
 }
 
 // Clear removes all entries from the cache and the repository.
 func (p *PersistentCache) Clear() error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	p.clearMutex.Lock()
+	defer p.clearMutex.Unlock()
 
 	err := p.cache.Clear()
 	if err != nil {
 		return err
 	}
 
+	if p.async != nil {
+		return p.async.EnqueueClear()
+	}
 	return p.repo.Clear()
 }
+
+// DeadLetters returns the write-behind writes that could not be persisted.
+func (p *PersistentCache) DeadLetters() ([]*DeadLetter, error) {
+	if p.async == nil {
+		return nil, ErrNoDeadLetterStore
+	}
+	return p.async.DeadLetters()
+}
+
+// ReplayDeadLetters retries the write-behind writes that could not be
+// persisted, for example once the database has recovered. See
+// AsyncPersistenceManager.ReplayDeadLetters.
+func (p *PersistentCache) ReplayDeadLetters() (int, error) {
+	if p.async == nil {
+		return 0, ErrNoDeadLetterStore
+	}
+	return p.async.ReplayDeadLetters()
+}
+
+// DismissDeadLetters forgets the write-behind writes that could not be
+// persisted, including failed clears, without writing them. See
+// AsyncPersistenceManager.DismissDeadLetters.
+func (p *PersistentCache) DismissDeadLetters() error {
+	if p.async == nil {
+		return ErrNoDeadLetterStore
+	}
+	return p.async.DismissDeadLetters()
+}
+
+// Shutdown flushes queued write-behind tasks until ctx is done and closes the
+// wrapped cache and the repository. If a write is still in progress when ctx
+// ends, Shutdown returns without waiting for it and the repository is closed
+// in the background once it finishes. It returns the number of writes that
+// were not persisted; see AsyncPersistenceManager.Shutdown.
+func (p *PersistentCache) Shutdown(ctx context.Context) (int, error) {
+	var lost int
+	var err error
+	if p.async != nil {
+		lost, err = p.async.Shutdown(ctx)
+	}
+	if closeErr := p.cache.Close(); err == nil {
+		err = closeErr
+	}
+	if p.async != nil {
+		select {
+		case <-p.async.Stopped():
+		default:
+			go func() {
+				<-p.async.Stopped()
+				if err := p.repo.Close(); err != nil {
+					log.Printf("Failed to close repository after shutdown: %v", err)
+				}
+			}()
+			return lost, err
+		}
+	}
+	if closeErr := p.repo.Close(); err == nil {
+		err = closeErr
+	}
+	return lost, err
+}
+
+// Close flushes all queued write-behind tasks and closes the wrapped cache and
+// the repository.
+func (p *PersistentCache) Close() error {
+	lost, err := p.Shutdown(context.Background())
+	if lost > 0 {
+		log.Printf("%d write-behind writes could not be persisted", lost)
+	}
+	return err
+}
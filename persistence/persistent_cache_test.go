@@ -0,0 +1,322 @@
+// File: persistent_cache_test.go
+
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cachefy/backends/inmemory"
+	"cachefy/repository"
+)
+
+// memoryRepository is an in-memory repository.Repository for tests.
+type memoryRepository struct {
+	mutex   sync.Mutex
+	entries map[string]*repository.CacheEntry
+	batches int
+	closed  bool
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{entries: make(map[string]*repository.CacheEntry)}
+}
+
+func (r *memoryRepository) Get(key string) (*repository.CacheEntry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, ok := r.entries[key]
+	if !ok {
+		return nil, repository.ErrKeyNotFound
+	}
+	if entry.Expired(time.Now()) {
+		delete(r.entries, key)
+		return nil, repository.ErrKeyExpired
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+func (r *memoryRepository) Set(entry *repository.CacheEntry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	copied := *entry
+	r.entries[entry.Key] = &copied
+	return nil
+}
+
+func (r *memoryRepository) Delete(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.entries, key)
+	return nil
+}
+
+func (r *memoryRepository) Clear() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.entries = make(map[string]*repository.CacheEntry)
+	return nil
+}
+
+func (r *memoryRepository) WriteBatch(entries []*repository.CacheEntry, deletes []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.batches++
+	for _, entry := range entries {
+		copied := *entry
+		r.entries[entry.Key] = &copied
+	}
+	for _, key := range deletes {
+		delete(r.entries, key)
+	}
+	return nil
+}
+
+func (r *memoryRepository) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	return nil
+}
+
+func (r *memoryRepository) Paginate(offset, limit int) ([]*repository.CacheEntry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var entries []*repository.CacheEntry
+	for i := offset; i < len(keys) && i < offset+limit; i++ {
+		copied := *r.entries[keys[i]]
+		entries = append(entries, &copied)
+	}
+	return entries, nil
+}
+
+func TestPersistentCacheReadThrough(t *testing.T) {
+	repo := newMemoryRepository()
+	repo.Set(&repository.CacheEntry{
+		Key:       "key1",
+		Value:     "value1",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+
+	memory := inmemory.NewRWMutexCache(5 * time.Minute)
+	cache := NewPersistentCache(memory, repo)
+
+	// Test read-through on a cold cache
+	val, err := cache.Get("key1")
+	if err != nil || val != "value1" {
+		t.Fatalf("Expected value1, got %v, error: %v", val, err)
+	}
+
+	// Test that the in-memory tier was repopulated with the remaining TTL
+	ttl, err := memory.TTL("key1")
+	if err != nil || ttl <= 5*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected TTL close to one hour, got %v, error: %v", ttl, err)
+	}
+
+	// Test miss in both tiers
+	if _, err := cache.Get("missing"); err != inmemory.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestPersistentCacheExpiry(t *testing.T) {
+	repo := newMemoryRepository()
+	cache := NewPersistentCache(inmemory.NewRWMutexCache(5*time.Minute), repo)
+
+	cache.Set("default", "value")
+	cache.SetWithTTL("forever", "value", 0)
+	cache.SetWithTTL("touched", "value", time.Minute)
+	cache.Touch("touched", time.Hour)
+
+	entry, err := repo.Get("default")
+	if err != nil {
+		t.Fatalf("Expected persisted entry to survive, error: %v", err)
+	}
+	if remaining := time.Until(time.Unix(entry.ExpiresAt, 0)); remaining < 4*time.Minute || remaining > 5*time.Minute {
+		t.Errorf("Expected ExpiresAt about five minutes ahead, got %v", remaining)
+	}
+
+	entry, err = repo.Get("forever")
+	if err != nil || entry.ExpiresAt != repository.NeverExpires {
+		t.Errorf("Expected NeverExpires, got %+v, error: %v", entry, err)
+	}
+
+	entry, err = repo.Get("touched")
+	if err != nil || time.Until(time.Unix(entry.ExpiresAt, 0)) < 59*time.Minute {
+		t.Errorf("Expected Touch to extend the persisted expiry, got %+v, error: %v", entry, err)
+	}
+}
+
+func TestPersistentCacheWarmUp(t *testing.T) {
+	repo := newMemoryRepository()
+	for i := 0; i < 250; i++ {
+		repo.Set(&repository.CacheEntry{
+			Key:       fmt.Sprintf("key%03d", i),
+			Value:     i,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		})
+	}
+	repo.Set(&repository.CacheEntry{Key: "expired", Value: 0, ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+
+	// Test loading everything into an unbounded cache
+	memory := inmemory.NewRWMutexCache(5 * time.Minute)
+	loaded, err := NewPersistentCache(memory, repo).WarmUp(100)
+	if err != nil || loaded != 250 {
+		t.Fatalf("Expected 250 entries loaded, got %d, error: %v", loaded, err)
+	}
+	if _, err := memory.Get("expired"); err == nil {
+		t.Errorf("Expected expired entry to be skipped")
+	}
+
+	// Test that a bounded cache is not overfilled
+	sharded := inmemory.NewShardedCache(4, 5*time.Minute, 10)
+	loaded, err = NewPersistentCache(sharded, repo).WarmUp(100)
+	if err != nil || loaded > 40 || sharded.Len() != loaded {
+		t.Errorf("Expected at most 40 entries loaded without eviction, got %d (len %d), error: %v", loaded, sharded.Len(), err)
+	}
+}
+
+func TestPersistentCacheClose(t *testing.T) {
+	repo := newMemoryRepository()
+	cache := NewPersistentCache(inmemory.NewRWMutexCache(5*time.Minute), repo)
+
+	// Test that closing the cache closes its repository
+	if err := cache.Close(); err != nil {
+		t.Errorf("Expected Close to succeed, error: %v", err)
+	}
+	if !repo.closed {
+		t.Errorf("Expected repository to be closed")
+	}
+}
+
+// slowGetRepository holds every Get of the key "slow" until release is closed.
+type slowGetRepository struct {
+	*memoryRepository
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r *slowGetRepository) Get(key string) (*repository.CacheEntry, error) {
+	if key == "slow" {
+		r.entered <- struct{}{}
+		<-r.release
+	}
+	return r.memoryRepository.Get(key)
+}
+
+func TestPersistentCacheKeyLocking(t *testing.T) {
+	repo := &slowGetRepository{
+		memoryRepository: newMemoryRepository(),
+		entered:          make(chan struct{}),
+		release:          make(chan struct{}),
+	}
+	repo.Set(&repository.CacheEntry{Key: "slow", Value: "persisted", ExpiresAt: repository.NeverExpires})
+	repo.Set(&repository.CacheEntry{Key: "other", Value: "persisted", ExpiresAt: repository.NeverExpires})
+	cache := NewPersistentCache(inmemory.NewRWMutexCache(5*time.Minute), repo)
+
+	slow := make(chan interface{})
+	go func() {
+		value, _ := cache.Get("slow")
+		slow <- value
+	}()
+	<-repo.entered
+
+	// Test that a read-through of one key does not hold up other keys
+	done := make(chan error)
+	go func() {
+		if _, err := cache.Get("other"); err != nil {
+			done <- err
+			return
+		}
+		done <- cache.Set("new", "value")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected other keys to be read and written, error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected other keys not to wait for the read of slow")
+	}
+
+	// Test that a write to the same key waits for the read, so it is not
+	// overwritten by the persisted value
+	written := make(chan error)
+	go func() {
+		written <- cache.Set("slow", "written")
+	}()
+	select {
+	case <-written:
+		t.Errorf("Expected Set to wait for the read of the same key")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(repo.release)
+	if value := <-slow; value != "persisted" {
+		t.Errorf("Expected persisted, got %v", value)
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Expected Set to succeed, error: %v", err)
+	}
+	if value, err := cache.Get("slow"); err != nil || value != "written" {
+		t.Errorf("Expected written, got %v, error: %v", value, err)
+	}
+}
+
+func TestWriteBehindCacheKeyLocking(t *testing.T) {
+	manager, repo := newStalledManager(t, AsyncPersistenceConfig{})
+	cache := NewWriteBehindCache(inmemory.NewRWMutexCache(5*time.Minute), repo, manager)
+
+	waiting := make(chan error)
+	go func() {
+		waiting <- cache.Set("waiting", "value")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Test that a write waiting for room in the queue holds no lock
+	done := make(chan error)
+	go func() {
+		if value, err := cache.Get("waiting"); err != nil || value != "value" {
+			done <- fmt.Errorf("expected value, got %v, error: %v", value, err)
+			return
+		}
+		done <- cache.Clear()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Get and Clear to succeed, error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Get and Clear not to wait for the queued write")
+	}
+
+	// Test that the write, prepared before Clear, is superseded by it
+	close(repo.release)
+	if err := <-waiting; err != nil {
+		t.Errorf("Expected Set to succeed, error: %v", err)
+	}
+	if _, err := cache.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected Shutdown to succeed, error: %v", err)
+	}
+	if _, err := repo.memoryRepository.Get("waiting"); err != repository.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
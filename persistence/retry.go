@@ -0,0 +1,92 @@
+// File: retry.go
+
+package persistence
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed repository write is retried.
+// Implementations must be safe for concurrent use by several workers.
+type RetryPolicy interface {
+	// Next is called after attempt number attempt failed with err. It returns
+	// how long to wait before the next attempt, or false to give up.
+	Next(attempt int, err error) (time.Duration, bool)
+}
+
+const (
+	defaultRetryInitialDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay     = 30 * time.Second
+	defaultRetryMultiplier   = 2
+	defaultRetryJitter       = 0.2
+)
+
+// BackoffPolicy retries with exponentially growing, jittered delays.
+type BackoffPolicy struct {
+	MaxAttempts  int              // Attempts including the first; values < 1 mean a single attempt
+	InitialDelay time.Duration    // Delay before the first retry; defaults to 100ms
+	MaxDelay     time.Duration    // Upper bound on any delay; defaults to 30s
+	Multiplier   float64          // Growth factor per attempt; defaults to 2
+	Jitter       float64          // Fraction of each delay that is randomized, from 0 to 1
+	Retryable    func(error) bool // Reports whether an error is worth retrying; nil retries every error
+
+	mutex sync.Mutex
+	rng   *rand.Rand
+}
+
+// NewBackoffPolicy returns a BackoffPolicy with maxAttempts attempts and
+// the default delays and a jitter of 0.2.
+func NewBackoffPolicy(maxAttempts int) *BackoffPolicy {
+	return &BackoffPolicy{
+		MaxAttempts:  maxAttempts,
+		InitialDelay: defaultRetryInitialDelay,
+		MaxDelay:     defaultRetryMaxDelay,
+		Multiplier:   defaultRetryMultiplier,
+		Jitter:       defaultRetryJitter,
+	}
+}
+
+// Next returns InitialDelay * Multiplier^(attempt-1), capped at MaxDelay and
+// reduced by up to Jitter of itself, until MaxAttempts is reached or
+// Retryable rejects err.
+func (p *BackoffPolicy) Next(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || (p.Retryable != nil && !p.Retryable(err)) {
+		return 0, false
+	}
+
+	initial, maxDelay, multiplier := p.InitialDelay, p.MaxDelay, p.Multiplier
+	if initial <= 0 {
+		initial = defaultRetryInitialDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	delay := float64(initial)
+	for i := 1; i < attempt && delay < float64(maxDelay); i++ {
+		delay *= multiplier
+	}
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * p.Jitter * p.random()
+	}
+	return time.Duration(delay), true
+}
+
+// random returns a number in [0, 1).
+func (p *BackoffPolicy) random() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.rng == nil {
+		p.rng = rand.New(rand.NewSource(rand.Int63()))
+	}
+	return p.rng.Float64()
+}
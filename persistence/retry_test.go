@@ -0,0 +1,51 @@
+// File: retry_test.go
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicy(t *testing.T) {
+	policy := &BackoffPolicy{
+		MaxAttempts:  5,
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     300 * time.Millisecond,
+		Multiplier:   2,
+	}
+	failure := errors.New("database unavailable")
+
+	// Test that delays grow exponentially up to MaxDelay
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, want := range expected {
+		if delay, ok := policy.Next(i+1, failure); !ok || delay != want {
+			t.Errorf("Expected %v after attempt %d, got %v, retry: %v", want, i+1, delay, ok)
+		}
+	}
+
+	// Test that the policy gives up after MaxAttempts
+	if _, ok := policy.Next(5, failure); ok {
+		t.Errorf("Expected no retry after MaxAttempts")
+	}
+
+	// Test that non-retryable errors are not retried
+	permanent := errors.New("constraint violation")
+	policy.Retryable = func(err error) bool { return !errors.Is(err, permanent) }
+	if _, ok := policy.Next(1, permanent); ok {
+		t.Errorf("Expected no retry for a non-retryable error")
+	}
+	if _, ok := policy.Next(1, failure); !ok {
+		t.Errorf("Expected a retry for a retryable error")
+	}
+
+	// Test that jitter only shortens the delay
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay, _ := policy.Next(1, failure)
+		if delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+			t.Fatalf("Expected jittered delay between 50ms and 100ms, got %v", delay)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// File: snapshot_cache.go
+
+package persistence
+
+import (
+	"cachefy/interfaces"
+	"cachefy/serialization"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SnapshotableCache is a cache that can write its entries to a snapshot and
+// restore them from one, such as the rwmutex, syncmap and sharded backends.
+type SnapshotableCache interface {
+	interfaces.Cache
+	Snapshot(w io.Writer, serializer serialization.Serializer) (int, error)
+	Restore(r io.Reader, serializer serialization.Serializer) (int, error)
+}
+
+// SnapshotConfig configures a SnapshotCache.
+type SnapshotConfig struct {
+	Path        string                   // Snapshot file; older generations are kept as Path.1, Path.2, ...
+	Interval    time.Duration            // How often a snapshot is written
+	Generations int                      // Snapshots kept, including the latest; defaults to 3
+	Serializer  serialization.Serializer // Encoding of snapshots; nil selects gob
+	StartEmpty  bool                     // Start empty, with snapshots suspended, if no snapshot can be restored
+}
+
+const defaultSnapshotGenerations = 3
+
+// ErrSnapshotsSuspended is returned by WriteSnapshot when the cache could not
+// be restored from its snapshots and was started empty. Writing more would
+// rotate away the generations that failed to restore, and so may still be
+// recovered by hand.
+var ErrSnapshotsSuspended = errors.New("snapshots suspended after a failed restore")
+
+// SnapshotCache wraps a cache and periodically writes it to a snapshot file,
+// giving the in-memory backends durability across restarts without a
+// database. Each snapshot is written to a temporary file, fsynced and renamed
+// into place, after the previous generations have been shifted along.
+type SnapshotCache struct {
+	interfaces.Cache
+	cache     SnapshotableCache
+	config    SnapshotConfig
+	mutex     sync.Mutex // Serializes snapshot writes
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	restored   int
+	restoreErr error // Set if restoring failed, suspending snapshots
+}
+
+// NewSnapshotCache wraps cache and restores it from the most recent snapshot
+// that can be read, falling back to older generations if newer ones are
+// missing or corrupt. It then writes snapshots every config.Interval, and
+// Close writes a final snapshot and closes cache.
+//
+// If there are snapshots but none can be restored, for example because the
+// serializer or encryption key changed, NewSnapshotCache returns the error.
+// With config.StartEmpty set, cache is left empty instead and no snapshots are
+// written, so that the existing ones are kept for recovery; Restored reports
+// the error.
+func NewSnapshotCache(cache SnapshotableCache, config SnapshotConfig) (*SnapshotCache, error) {
+	if config.Path == "" {
+		return nil, errors.New("snapshot path is required")
+	}
+	if config.Interval <= 0 {
+		return nil, errors.New("snapshot interval must be greater than zero")
+	}
+	if config.Generations <= 0 {
+		config.Generations = defaultSnapshotGenerations
+	}
+
+	s := &SnapshotCache{
+		Cache:  cache,
+		cache:  cache,
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.restored, s.restoreErr = s.restore()
+	if s.restoreErr != nil && !config.StartEmpty {
+		return nil, fmt.Errorf("restoring snapshot %s: %w", config.Path, s.restoreErr)
+	}
+	if s.restoreErr != nil {
+		log.Printf("Failed to restore snapshot %s, suspending snapshots: %v", config.Path, s.restoreErr)
+		close(s.done)
+		return s, nil
+	}
+	go s.run()
+	return s, nil
+}
+
+// Restored returns the number of entries restored when the cache was created,
+// or the error that suspended snapshots.
+func (s *SnapshotCache) Restored() (int, error) {
+	return s.restored, s.restoreErr
+}
+
+// generationPath returns the file of the given generation, where 0 is the latest.
+func (s *SnapshotCache) generationPath(generation int) string {
+	if generation == 0 {
+		return s.config.Path
+	}
+	return fmt.Sprintf("%s.%d", s.config.Path, generation)
+}
+
+// restore loads the most recent snapshot that can be read. It returns the
+// number of entries restored, and nil if there is no snapshot at all.
+func (s *SnapshotCache) restore() (int, error) {
+	var lastErr error
+	for generation := 0; generation < s.config.Generations; generation++ {
+		path := s.generationPath(generation)
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			lastErr = err
+			continue
+		}
+
+		restored, err := s.cache.Restore(file, s.config.Serializer)
+		file.Close()
+		if err == nil {
+			return restored, nil
+		}
+		log.Printf("Skipping snapshot %s: %v", path, err)
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+// WriteSnapshot writes a snapshot now and rotates the older generations. It
+// returns the number of entries written.
+func (s *SnapshotCache) WriteSnapshot() (int, error) {
+	if s.restoreErr != nil {
+		return 0, fmt.Errorf("%w: %w", ErrSnapshotsSuspended, s.restoreErr)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := filepath.Dir(s.config.Path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.config.Path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := s.cache.Snapshot(tmp, s.config.Serializer)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Shift each generation to the next, dropping the oldest.
+	for generation := s.config.Generations - 1; generation > 0; generation-- {
+		err := os.Rename(s.generationPath(generation-1), s.generationPath(generation))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	if err := os.Rename(tmp.Name(), s.config.Path); err != nil {
+		return 0, err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return written, nil
+}
+
+// run writes a snapshot every interval until Close is called.
+func (s *SnapshotCache) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.WriteSnapshot(); err != nil {
+				log.Printf("Failed to write snapshot %s: %v", s.config.Path, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic snapshots, writes a final snapshot unless they are
+// suspended, and closes the wrapped cache.
+func (s *SnapshotCache) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+
+		if s.restoreErr == nil {
+			_, err = s.WriteSnapshot()
+		}
+		if closeErr := s.cache.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
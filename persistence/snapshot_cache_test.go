@@ -0,0 +1,129 @@
+// File: snapshot_cache_test.go
+
+package persistence
+
+import (
+	"cachefy/backends/inmemory"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	config := SnapshotConfig{Path: path, Interval: time.Hour, Generations: 2}
+
+	cache, err := NewSnapshotCache(inmemory.NewRWMutexCache(time.Minute), config)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot cache: %v", err)
+	}
+
+	// Test that generations rotate and only the configured number are kept
+	for i := 1; i <= 3; i++ {
+		cache.Set("key", i)
+		if written, err := cache.WriteSnapshot(); err != nil || written != 1 {
+			t.Fatalf("Expected 1 entry written, got %d, error: %v", written, err)
+		}
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("Expected previous generation to be kept, error: %v", err)
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 generations, got error: %v", err)
+	}
+
+	// Test that Close writes a final snapshot
+	cache.Set("key", 4)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot cache: %v", err)
+	}
+	restored, err := NewSnapshotCache(inmemory.NewRWMutexCache(time.Minute), config)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot cache: %v", err)
+	}
+	defer restored.Close()
+	if count, err := restored.Restored(); err != nil || count != 1 {
+		t.Fatalf("Expected 1 entry restored, got %d, error: %v", count, err)
+	}
+	if value, err := restored.Get("key"); err != nil || value != 4 {
+		t.Errorf("Expected 4 from the final snapshot, got %v, error: %v", value, err)
+	}
+
+	// Test that a corrupt latest snapshot falls back to the previous generation
+	if err := os.WriteFile(path, []byte("corrupt"), 0o644); err != nil {
+		t.Fatalf("Failed to corrupt snapshot: %v", err)
+	}
+	fallback, err := NewSnapshotCache(inmemory.NewRWMutexCache(time.Minute), config)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot cache: %v", err)
+	}
+	defer fallback.Close()
+	if _, err := fallback.Restored(); err != nil {
+		t.Fatalf("Failed to restore previous generation: %v", err)
+	}
+	if value, err := fallback.Get("key"); err != nil || value != 3 {
+		t.Errorf("Expected 3 from the previous generation, got %v, error: %v", value, err)
+	}
+}
+
+func TestSnapshotCacheFailedRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	if err := os.WriteFile(path, []byte("corrupt"), 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	config := SnapshotConfig{Path: path, Interval: time.Millisecond}
+
+	// Test that a failed restore is returned
+	if _, err := NewSnapshotCache(inmemory.NewRWMutexCache(time.Minute), config); err == nil {
+		t.Errorf("Expected the restore error")
+	}
+
+	config.StartEmpty = true
+	cache, err := NewSnapshotCache(inmemory.NewRWMutexCache(time.Minute), config)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot cache: %v", err)
+	}
+
+	// Test that starting empty suspends snapshots and keeps the existing ones
+	if _, err := cache.Restored(); err == nil {
+		t.Errorf("Expected the restore to fail")
+	}
+	cache.Set("key", "value")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.WriteSnapshot(); !errors.Is(err, ErrSnapshotsSuspended) {
+		t.Errorf("Expected ErrSnapshotsSuspended, got %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Errorf("Failed to close snapshot cache: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "corrupt" {
+		t.Errorf("Expected the snapshot to be kept, got %q, error: %v", data, err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("Expected no rotated generation, got error: %v", err)
+	}
+}
+
+func TestSnapshotCachePeriodic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	cache, err := NewSnapshotCache(inmemory.NewSyncMapCache(time.Minute), SnapshotConfig{Path: path, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create snapshot cache: %v", err)
+	}
+	defer cache.Close()
+
+	// Test that snapshots are written in the background
+	cache.Set("key", "value")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a snapshot to be written within a second")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+// File: spill.go
+
+package persistence
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"cachefy/serialization"
+)
+
+// spillRecord is the on-disk form of a persistenceTask in the overflow file.
+type spillRecord struct {
+	Seq     uint64        `json:"seq"`
+	Op      taskOp        `json:"op"`
+	Key     string        `json:"key"`
+	Encoded *encodedEntry `json:"encoded,omitempty"`
+}
+
+// spillFile is an append-only overflow file of tasks that did not fit in the
+// queue, one JSON record per line. Values are encoded with the serializer and
+// tagged with their type, so they are read back as their original types.
+type spillFile struct {
+	mutex      sync.Mutex
+	file       *os.File
+	serializer serialization.Serializer
+	count      int
+}
+
+// openSpillFile opens or creates the overflow file at path, encoding values
+// with serializer; a nil serializer selects serialization.BlobSerializer.
+// Records left over from a previous run are kept and returned by the next
+// drain.
+func openSpillFile(path string, serializer serialization.Serializer) (*spillFile, error) {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	spill := &spillFile{file: file, serializer: serializer}
+	records, err := spill.read()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	spill.count = len(records)
+	return spill, nil
+}
+
+// append writes task to the end of the file.
+func (s *spillFile) append(task *persistenceTask) error {
+	record := spillRecord{Seq: task.seq, Op: task.op, Key: task.key}
+	if task.entry != nil {
+		encoded, err := encodeEntry(s.serializer, task.entry)
+		if err != nil {
+			return err
+		}
+		record.Encoded = encoded
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// len returns the number of tasks in the file.
+func (s *spillFile) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.count
+}
+
+// drain returns all tasks in the file, oldest first, and truncates it. Tasks
+// whose values cannot be decoded are logged and discarded; their keys are
+// returned alongside.
+func (s *spillFile) drain() ([]*persistenceTask, []string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.count == 0 {
+		return nil, nil, nil
+	}
+	records, err := s.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return nil, nil, err
+	}
+	s.count = 0
+
+	tasks := make([]*persistenceTask, 0, len(records))
+	var discarded []string
+	for _, record := range records {
+		task := &persistenceTask{seq: record.Seq, op: record.Op, key: record.Key}
+		if record.Encoded != nil {
+			entry, err := record.Encoded.decode(s.serializer)
+			if err != nil {
+				log.Printf("Discarding spilled write of key %q: %v", record.Key, err)
+				discarded = append(discarded, record.Key)
+				continue
+			}
+			task.entry = entry
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, discarded, nil
+}
+
+// read decodes every record in the file. The caller must hold the mutex or
+// have exclusive access to the file.
+func (s *spillFile) read() ([]spillRecord, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var records []spillRecord
+	scanner := bufio.NewScanner(s.file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record spillRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Close closes the file, keeping any remaining records for the next run.
+func (s *spillFile) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,560 @@
+// File: file_repository.go
+
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"cachefy/serialization"
+)
+
+// FileRepository is a repository kept in a single append-only log file, in the
+// style of Bitcask. Every write appends a CRC-checked record to the file and
+// deletes append tombstones. An in-memory key directory records where the
+// latest value of each key lives, so a read costs one positioned read.
+// Records made obsolete by later writes are reclaimed by compaction, which
+// rewrites the live entries into a new file.
+//
+// Records are not fsynced as they are written: they survive a crash of the
+// process, but the most recent ones may be lost if the machine fails. A torn
+// record at the end of the file is discarded when the file is reopened.
+type FileRepository struct {
+	mutex      sync.RWMutex
+	path       string
+	file       *os.File
+	size       int64
+	garbage    int64 // Bytes of records made obsolete by later writes
+	keys       map[string]fileKeyEntry
+	serializer serialization.Serializer
+	stop       chan struct{}
+	done       chan struct{}
+
+	// DeleteCorrupt makes Get and Paginate delete entries whose values fail
+	// their integrity checks, rather than returning a CorruptValueError.
+	// It must be set before the repository is used.
+	DeleteCorrupt bool
+}
+
+// fileKeyEntry locates the latest value of a key in the log file.
+type fileKeyEntry struct {
+	valueOffset int64
+	valueLen    uint32
+	recordSize  int64
+	expiresAt   int64
+	tag         string
+}
+
+// Log file layout. The file is a sequence of frames, each holding the records
+// of one write, so that a batch is applied entirely or not at all:
+//
+//	frame:  crc32 (of body) uint32 | body length uint32 | body
+//	record: kind byte | expires_at int64 | key length uint32 | tag length uint16 |
+//	        value length uint32 | key | tag | value
+//
+// All integers are big-endian.
+const (
+	fileFrameHeaderSize  = 4 + 4
+	fileRecordHeaderSize = 1 + 8 + 4 + 2 + 4
+
+	fileRecordPut    byte = 1
+	fileRecordDelete byte = 2
+)
+
+const (
+	fileCompactionInterval   = time.Minute
+	fileCompactionMinGarbage = 1 << 20 // Compact once at least 1 MiB, and half the file, is garbage
+	fileCompactionFrameSize  = 1 << 20 // Largest frame written by compaction
+)
+
+// errCorruptLog indicates that the log file holds a frame that cannot be read.
+var errCorruptLog = errors.New("corrupt log record")
+
+// fileRecord is a record to be appended to the log file.
+type fileRecord struct {
+	kind      byte
+	key       string
+	tag       string
+	value     []byte
+	expiresAt int64
+}
+
+func (r *fileRecord) size() int64 {
+	return int64(fileRecordHeaderSize + len(r.key) + len(r.tag) + len(r.value))
+}
+
+// NewFileRepository opens the log file at path, creating it if needed, and
+// loads its key directory. Values are encoded with serializer; a nil serializer
+// selects serialization.BlobSerializer. Compaction runs in the background
+// until the repository is closed.
+func NewFileRepository(path string, serializer serialization.Serializer) (*FileRepository, error) {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &FileRepository{
+		path:       path,
+		file:       file,
+		keys:       make(map[string]fileKeyEntry),
+		serializer: serializer,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := repo.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	go repo.compactor()
+	return repo, nil
+}
+
+// load rebuilds the key directory from the log file. A torn or corrupt frame
+// ends the log: it and anything after it are truncated away.
+func (r *FileRepository) load() error {
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(r.file, 0, info.Size()))
+	header := make([]byte, fileFrameHeaderSize)
+	var offset int64
+	for {
+		_, err := io.ReadFull(reader, header)
+		if err == io.EOF {
+			break
+		}
+		var body []byte
+		if err == nil {
+			length := int64(binary.BigEndian.Uint32(header[4:8]))
+			if offset+fileFrameHeaderSize+length > info.Size() {
+				err = io.ErrUnexpectedEOF
+			} else {
+				body = make([]byte, length)
+				_, err = io.ReadFull(reader, body)
+			}
+		}
+		if err == nil && crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[0:4]) {
+			err = fmt.Errorf("%w: checksum mismatch", errCorruptLog)
+		}
+		if err == nil {
+			err = r.apply(offset, body)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errCorruptLog) {
+			log.Printf("Truncating %s at offset %d: %v", r.path, offset, err)
+			if err := r.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		offset += int64(fileFrameHeaderSize + len(body))
+	}
+	r.size = offset
+	return nil
+}
+
+// apply updates the key directory with the records of the frame whose body
+// starts at offset + fileFrameHeaderSize. The caller must hold the write lock,
+// or have exclusive access while loading.
+func (r *FileRepository) apply(offset int64, body []byte) error {
+	position := 0
+	for position < len(body) {
+		if len(body)-position < fileRecordHeaderSize {
+			return fmt.Errorf("%w: truncated record header", errCorruptLog)
+		}
+		header := body[position : position+fileRecordHeaderSize]
+		kind := header[0]
+		expiresAt := int64(binary.BigEndian.Uint64(header[1:9]))
+		keyLen := int(binary.BigEndian.Uint32(header[9:13]))
+		tagLen := int(binary.BigEndian.Uint16(header[13:15]))
+		valueLen := binary.BigEndian.Uint32(header[15:19])
+
+		recordSize := fileRecordHeaderSize + keyLen + tagLen + int(valueLen)
+		if recordSize > len(body)-position {
+			return fmt.Errorf("%w: truncated record", errCorruptLog)
+		}
+		keyStart := position + fileRecordHeaderSize
+		key := string(body[keyStart : keyStart+keyLen])
+		tag := string(body[keyStart+keyLen : keyStart+keyLen+tagLen])
+
+		if old, ok := r.keys[key]; ok {
+			r.garbage += old.recordSize
+		}
+		switch kind {
+		case fileRecordPut:
+			r.keys[key] = fileKeyEntry{
+				valueOffset: offset + fileFrameHeaderSize + int64(keyStart+keyLen+tagLen),
+				valueLen:    valueLen,
+				recordSize:  int64(recordSize),
+				expiresAt:   expiresAt,
+				tag:         tag,
+			}
+		case fileRecordDelete:
+			delete(r.keys, key)
+			r.garbage += int64(recordSize)
+		default:
+			return fmt.Errorf("%w: unknown record kind %d", errCorruptLog, kind)
+		}
+		position += recordSize
+	}
+	return nil
+}
+
+// encodeFrame lays records out as one frame.
+func encodeFrame(records []*fileRecord) []byte {
+	size := fileFrameHeaderSize
+	for _, record := range records {
+		size += int(record.size())
+	}
+	frame := make([]byte, fileFrameHeaderSize, size)
+	for _, record := range records {
+		var header [fileRecordHeaderSize]byte
+		header[0] = record.kind
+		binary.BigEndian.PutUint64(header[1:9], uint64(record.expiresAt))
+		binary.BigEndian.PutUint32(header[9:13], uint32(len(record.key)))
+		binary.BigEndian.PutUint16(header[13:15], uint16(len(record.tag)))
+		binary.BigEndian.PutUint32(header[15:19], uint32(len(record.value)))
+		frame = append(frame, header[:]...)
+		frame = append(frame, record.key...)
+		frame = append(frame, record.tag...)
+		frame = append(frame, record.value...)
+	}
+	body := frame[fileFrameHeaderSize:]
+	binary.BigEndian.PutUint32(frame[0:4], crc32.ChecksumIEEE(body))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(body)))
+	return frame
+}
+
+// write appends records to the log as one frame and applies them to the key
+// directory. The caller must hold the write lock.
+func (r *FileRepository) write(records []*fileRecord) error {
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	frame := encodeFrame(records)
+	if _, err := r.file.WriteAt(frame, r.size); err != nil {
+		// Drop any partial frame so later frames follow a complete one.
+		r.file.Truncate(r.size)
+		return err
+	}
+	if err := r.apply(r.size, frame[fileFrameHeaderSize:]); err != nil {
+		return err
+	}
+	r.size += int64(len(frame))
+	return nil
+}
+
+// putRecord encodes entry as a put record.
+func (r *FileRepository) putRecord(entry *CacheEntry) (*fileRecord, error) {
+	tag, data, err := EncodeValue(r.serializer, entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(tag) > 0xFFFF {
+		return nil, fmt.Errorf("type tag of key %q is too long", entry.Key)
+	}
+	return &fileRecord{kind: fileRecordPut, key: entry.Key, tag: tag, value: data, expiresAt: entry.ExpiresAt}, nil
+}
+
+func (r *FileRepository) Get(key string) (*CacheEntry, error) {
+	r.mutex.RLock()
+	entry, ok := r.keys[key]
+	var data []byte
+	var err error
+	if ok {
+		data, err = r.readValue(entry)
+	}
+	r.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Check expiration
+	if entry.expiresAt != NeverExpires && time.Now().Unix() > entry.expiresAt {
+		_ = r.Delete(key) // Automatically clean up expired entries
+		return nil, ErrKeyExpired
+	}
+
+	value, err := DecodeValue(r.serializer, key, entry.tag, data)
+	if err != nil {
+		if r.DeleteCorrupt {
+			return nil, deleteCorrupt(r, key, err)
+		}
+		return nil, err
+	}
+
+	return &CacheEntry{Key: key, Value: value, ExpiresAt: entry.expiresAt}, nil
+}
+
+// readValue reads the value of entry from the log. The caller must hold the lock.
+func (r *FileRepository) readValue(entry fileKeyEntry) ([]byte, error) {
+	if r.file == nil {
+		return nil, os.ErrClosed
+	}
+	data := make([]byte, entry.valueLen)
+	if _, err := r.file.ReadAt(data, entry.valueOffset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *FileRepository) Set(entry *CacheEntry) error {
+	record, err := r.putRecord(entry)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.write([]*fileRecord{record})
+}
+
+func (r *FileRepository) Delete(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.keys[key]; !ok {
+		return nil
+	}
+	return r.write([]*fileRecord{{kind: fileRecordDelete, key: key}})
+}
+
+// Clear truncates the log file.
+func (r *FileRepository) Clear() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if err := r.file.Truncate(0); err != nil {
+		return err
+	}
+	r.size = 0
+	r.garbage = 0
+	r.keys = make(map[string]fileKeyEntry)
+	return nil
+}
+
+// WriteBatch appends entries and deletes as a single frame, so that a crash
+// leaves either all or none of them in the log.
+func (r *FileRepository) WriteBatch(entries []*CacheEntry, deletes []string) error {
+	records := make([]*fileRecord, 0, len(entries)+len(deletes))
+	for _, entry := range entries {
+		record, err := r.putRecord(entry)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, key := range deletes {
+		if _, ok := r.keys[key]; ok {
+			records = append(records, &fileRecord{kind: fileRecordDelete, key: key})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return r.write(records)
+}
+
+func (r *FileRepository) Paginate(offset, limit int) ([]*CacheEntry, error) {
+	for {
+		entries, corrupt, err := r.paginate(offset, limit)
+		if err != nil || len(corrupt) == 0 {
+			return entries, err
+		}
+		// Deleting the corrupt entries shifts later keys into this page, so read it again.
+		log.Printf("Deleting %d corrupt cache entries", len(corrupt))
+		if err := r.WriteBatch(nil, corrupt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// paginate reads a page of entries in key order. With DeleteCorrupt set, the
+// keys of corrupt entries are returned instead of an error.
+func (r *FileRepository) paginate(offset, limit int) ([]*CacheEntry, []string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	keys := make([]string, 0, len(r.keys))
+	for key := range r.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if offset >= len(keys) {
+		return nil, nil, nil
+	}
+	keys = keys[offset:]
+	if limit < len(keys) {
+		keys = keys[:limit]
+	}
+
+	var entries []*CacheEntry
+	var corrupt []string
+	for _, key := range keys {
+		entry := r.keys[key]
+		data, err := r.readValue(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value, err := DecodeValue(r.serializer, key, entry.tag, data)
+		if r.DeleteCorrupt && errors.Is(err, ErrCorruptValue) {
+			corrupt = append(corrupt, key)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		entries = append(entries, &CacheEntry{Key: key, Value: value, ExpiresAt: entry.expiresAt})
+	}
+	return entries, corrupt, nil
+}
+
+// compactor compacts the log whenever enough of it is garbage.
+func (r *FileRepository) compactor() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(fileCompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mutex.RLock()
+			due := r.garbage >= fileCompactionMinGarbage && 2*r.garbage >= r.size
+			r.mutex.RUnlock()
+			if due {
+				if err := r.Compact(); err != nil {
+					log.Printf("Failed to compact %s: %v", r.path, err)
+				}
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Compact rewrites the live, unexpired entries into a new log file and
+// replaces the old one with it. Reads and writes wait while it runs.
+func (r *FileRepository) Compact() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*.compact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	compacted := &FileRepository{path: tmp.Name(), file: tmp, keys: make(map[string]fileKeyEntry)}
+	now := time.Now().Unix()
+	var records []*fileRecord
+	var frameSize int64
+	for key, entry := range r.keys {
+		if entry.expiresAt != NeverExpires && now > entry.expiresAt {
+			continue
+		}
+		data, err := r.readValue(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		record := &fileRecord{kind: fileRecordPut, key: key, tag: entry.tag, value: data, expiresAt: entry.expiresAt}
+		records = append(records, record)
+		frameSize += record.size()
+		if frameSize >= fileCompactionFrameSize {
+			if err := compacted.write(records); err != nil {
+				tmp.Close()
+				return err
+			}
+			records, frameSize = nil, 0
+		}
+	}
+	if len(records) > 0 {
+		if err := compacted.write(records); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	syncDir(filepath.Dir(r.path))
+
+	r.file.Close()
+	r.file = tmp
+	r.size = compacted.size
+	r.garbage = 0
+	r.keys = compacted.keys
+	return nil
+}
+
+// syncDir fsyncs a directory so that a rename within it is durable. Errors
+// are ignored, as not every platform supports it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Close stops compaction, syncs the log file and closes it.
+func (r *FileRepository) Close() error {
+	r.mutex.Lock()
+	select {
+	case <-r.stop:
+		r.mutex.Unlock()
+		return nil
+	default:
+		close(r.stop)
+	}
+	r.mutex.Unlock()
+	<-r.done
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.file.Sync()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file = nil
+	return err
+}
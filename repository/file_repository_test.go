@@ -0,0 +1,161 @@
+// File: file_repository_test.go
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cachefy/serialization"
+)
+
+func TestFileRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	repo, err := NewFileRepository(path, &serialization.BlobSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to create file repository: %v", err)
+	}
+
+	entry := &CacheEntry{
+		Key:       "key1",
+		Value:     "value1",
+		ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+	}
+
+	// Test Set and Get
+	if err := repo.Set(entry); err != nil {
+		t.Fatalf("Failed to set cache entry: %v", err)
+	}
+	retrieved, err := repo.Get("key1")
+	if err != nil || retrieved.Value != "value1" || retrieved.ExpiresAt != entry.ExpiresAt {
+		t.Fatalf("Failed to get cache entry: %v, error: %v", retrieved, err)
+	}
+
+	// Test WriteBatch and Delete
+	err = repo.WriteBatch([]*CacheEntry{{Key: "key2", Value: 2, ExpiresAt: NeverExpires}, {Key: "key3", Value: 3, ExpiresAt: NeverExpires}}, []string{"key1"})
+	if err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	if err := repo.Delete("key3"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	if _, err := repo.Get("key1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for key1, got %v", err)
+	}
+
+	// Test expired entries
+	repo.Set(&CacheEntry{Key: "expired", Value: "old", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if _, err := repo.Get("expired"); !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("Expected ErrKeyExpired, got %v", err)
+	}
+
+	// Test that the log is replayed when the file is reopened
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Failed to close file repository: %v", err)
+	}
+	repo, err = NewFileRepository(path, &serialization.BlobSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to reopen file repository: %v", err)
+	}
+	defer repo.Close()
+
+	entries, err := repo.Paginate(0, 10)
+	if err != nil || len(entries) != 1 || entries[0].Key != "key2" || entries[0].Value != 2 {
+		t.Errorf("Expected only key2 after reopening, got %v, error: %v", entries, err)
+	}
+
+	// Test Clear
+	if err := repo.Clear(); err != nil {
+		t.Fatalf("Failed to clear repository: %v", err)
+	}
+	if entries, _ := repo.Paginate(0, 10); len(entries) != 0 {
+		t.Errorf("Expected no entries after Clear, got %d", len(entries))
+	}
+}
+
+func TestFileRepositoryTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	repo, err := NewFileRepository(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to create file repository: %v", err)
+	}
+	repo.Set(&CacheEntry{Key: "kept", Value: "value", ExpiresAt: NeverExpires})
+	repo.WriteBatch([]*CacheEntry{{Key: "torn1", Value: "a", ExpiresAt: NeverExpires}, {Key: "torn2", Value: "b", ExpiresAt: NeverExpires}}, nil)
+	repo.Close()
+
+	// Cut the last frame short, as a crash in the middle of a write would
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("Failed to truncate log: %v", err)
+	}
+
+	// Test that the torn batch is discarded as a whole and the log stays usable
+	repo, err = NewFileRepository(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to reopen file repository: %v", err)
+	}
+	defer repo.Close()
+
+	if _, err := repo.Get("torn1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected torn batch to be discarded, got %v", err)
+	}
+	if entry, err := repo.Get("kept"); err != nil || entry.Value != "value" {
+		t.Errorf("Expected 'value' for kept, got %v, error: %v", entry, err)
+	}
+	if err := repo.Set(&CacheEntry{Key: "after", Value: "new", ExpiresAt: NeverExpires}); err != nil {
+		t.Fatalf("Failed to set entry after recovery: %v", err)
+	}
+	if entry, err := repo.Get("after"); err != nil || entry.Value != "new" {
+		t.Errorf("Expected 'new' for after, got %v, error: %v", entry, err)
+	}
+}
+
+func TestFileRepositoryCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	repo, err := NewFileRepository(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to create file repository: %v", err)
+	}
+	defer repo.Close()
+
+	for i := 0; i < 100; i++ {
+		repo.Set(&CacheEntry{Key: fmt.Sprintf("key%d", i%10), Value: i, ExpiresAt: NeverExpires})
+	}
+	repo.Delete("key9")
+	before, _ := os.Stat(path)
+
+	// Test that compaction shrinks the log and keeps the latest values
+	if err := repo.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("Expected log to shrink, got %d bytes from %d", after.Size(), before.Size())
+	}
+	for i := 0; i < 9; i++ {
+		entry, err := repo.Get(fmt.Sprintf("key%d", i))
+		if err != nil || entry.Value != 90+i {
+			t.Errorf("Expected %d for key%d, got %v, error: %v", 90+i, i, entry, err)
+		}
+	}
+	if _, err := repo.Get("key9"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected key9 to stay deleted, got %v", err)
+	}
+
+	// Test that writes after compaction survive reopening
+	repo.Set(&CacheEntry{Key: "key0", Value: "latest", ExpiresAt: NeverExpires})
+	repo.Close()
+	repo, err = NewFileRepository(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to reopen file repository: %v", err)
+	}
+	defer repo.Close()
+
+	if entry, err := repo.Get("key0"); err != nil || entry.Value != "latest" {
+		t.Errorf("Expected 'latest' for key0, got %v, error: %v", entry, err)
+	}
+}
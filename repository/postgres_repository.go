@@ -2,15 +2,30 @@ package repository
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"cachefy/serialization"
+
+	"github.com/lib/pq" // PostgreSQL driver
 )
 
+// ErrJSONBValueColumn is returned when the cache table still has the JSONB
+// value column of earlier versions, which MigratePostgresValueColumn converts.
+var ErrJSONBValueColumn = errors.New("cache table has a JSONB value column; convert it with MigratePostgresValueColumn")
+
 type PostgresRepository struct {
-	db *sql.DB
+	db         *sql.DB
+	serializer serialization.Serializer
+
+	// DeleteCorrupt makes Get and Paginate delete rows whose values fail
+	// their integrity checks, rather than returning a CorruptValueError.
+	// Get then reports ErrKeyNotFound wrapped around the corruption error.
+	// It must be set before the repository is used.
+	DeleteCorrupt bool
 }
 
 // SQL statements as constants
@@ -18,19 +33,37 @@ const (
 	sqlCreateTablePostgres = `
 	CREATE TABLE IF NOT EXISTS cache (
 		key TEXT PRIMARY KEY,
-		value JSONB,
-		expires_at BIGINT
+		value BYTEA,
+		expires_at BIGINT,
+		type_tag TEXT
 	)`
 
+	// Tables created before values were tagged lack the type_tag column.
+	sqlAddTypeTagColumnPostgres = `
+	ALTER TABLE cache ADD COLUMN IF NOT EXISTS type_tag TEXT`
+
+	// Tables created before values were serialized stored them as JSONB.
+	sqlValueColumnTypePostgres = `
+	SELECT data_type FROM information_schema.columns
+	WHERE table_schema = current_schema() AND table_name = 'cache' AND column_name = 'value'`
+
+	sqlMigrateValueColumnPostgres = `
+	ALTER TABLE cache ALTER COLUMN value TYPE BYTEA
+	USING convert_to(value::text, 'UTF8')`
+
+	sqlDeleteLegacyEntriesPostgres = `
+	DELETE FROM cache WHERE expires_at = 0`
+
 	sqlGetEntryPostgres = `
-	SELECT value, expires_at FROM cache WHERE key = $1`
+	SELECT value, expires_at, type_tag FROM cache WHERE key = $1`
 
 	sqlInsertOrUpdateEntryPostgres = `
-	INSERT INTO cache (key, value, expires_at)
-	VALUES ($1, $2, $3)
+	INSERT INTO cache (key, value, expires_at, type_tag)
+	VALUES ($1, $2, $3, $4)
 	ON CONFLICT (key) DO UPDATE
 	SET value = EXCLUDED.value,
-		expires_at = EXCLUDED.expires_at`
+		expires_at = EXCLUDED.expires_at,
+		type_tag = EXCLUDED.type_tag`
 
 	sqlDeleteEntryPostgres = `
 	DELETE FROM cache WHERE key = $1`
@@ -38,48 +71,122 @@ const (
 	sqlClearEntriesPostgres = `
 	DELETE FROM cache`
 
+	sqlInsertOrUpdateEntriesPrefixPostgres = `
+	INSERT INTO cache (key, value, expires_at, type_tag)
+	VALUES `
+
+	sqlInsertOrUpdateEntriesSuffixPostgres = `
+	ON CONFLICT (key) DO UPDATE
+	SET value = EXCLUDED.value,
+		expires_at = EXCLUDED.expires_at,
+		type_tag = EXCLUDED.type_tag`
+
+	sqlDeleteEntriesPostgres = `
+	DELETE FROM cache WHERE key = ANY($1)`
+
 	sqlPaginateEntriesPostgres = `
-	SELECT key, value, expires_at FROM cache
+	SELECT key, value, expires_at, type_tag FROM cache
 	ORDER BY key ASC LIMIT $1 OFFSET $2`
 )
 
-func NewPostgresRepository(dsn string) (*PostgresRepository, error) {
+// NewPostgresRepository creates a repository connected to a Postgres database.
+// Values are stored as BYTEA encoded with serializer; a nil serializer selects
+// serialization.JSONSerializer. A table with the JSONB value column of earlier
+// versions is left untouched and reported as ErrJSONBValueColumn, until it is
+// converted with MigratePostgresValueColumn. Values whose types
+// are registered with serialization.Register are tagged with their type name
+// and decoded into that type, rather than into maps and float64s.
+func NewPostgresRepository(dsn string, serializer serialization.Serializer) (*PostgresRepository, error) {
+	if serializer == nil {
+		serializer = &serialization.JSONSerializer{}
+	}
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
-	repo := &PostgresRepository{db: db}
+	repo := &PostgresRepository{db: db, serializer: serializer}
 	if err := repo.initTable(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return repo, nil
 }
 
 func (r *PostgresRepository) initTable() error {
-	_, err := r.db.Exec(sqlCreateTablePostgres)
+	if _, err := r.db.Exec(sqlCreateTablePostgres); err != nil {
+		return err
+	}
+	dataType, err := postgresValueColumnType(r.db)
+	if err != nil {
+		return err
+	}
+	if dataType == "jsonb" {
+		return ErrJSONBValueColumn
+	}
+	_, err = r.db.Exec(sqlAddTypeTagColumnPostgres)
+	return err
+}
+
+// postgresValueColumnType returns the data type of the cache table's value
+// column, or "" if there is no cache table.
+func postgresValueColumnType(db *sql.DB) (string, error) {
+	var dataType string
+	err := db.QueryRow(sqlValueColumnTypePostgres).Scan(&dataType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return dataType, err
+}
+
+// MigratePostgresValueColumn converts the JSONB value column of a cache table
+// created by earlier versions to BYTEA, keeping its JSON readable by
+// serialization.JSONSerializer. The conversion cannot be undone, and breaks
+// other readers that query the JSONB directly. Rows with an ExpiresAt of 0,
+// which those versions wrote on every row and read as expired, are deleted.
+// Tables that are already converted, or do not exist, are left as they are.
+func MigratePostgresValueColumn(dsn string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dataType, err := postgresValueColumnType(db)
+	if err != nil || dataType != "jsonb" {
+		return err
+	}
+	log.Printf("Converting the JSONB value column of the cache table to BYTEA")
+	if _, err := db.Exec(sqlMigrateValueColumnPostgres); err != nil {
+		return err
+	}
+	_, err = db.Exec(sqlDeleteLegacyEntriesPostgres)
 	return err
 }
 
 func (r *PostgresRepository) Get(key string) (*CacheEntry, error) {
 	row := r.db.QueryRow(sqlGetEntryPostgres, key)
 
-	var jsonValue []byte
+	var data []byte
 	var expiresAt int64
-	err := row.Scan(&jsonValue, &expiresAt)
+	var tag sql.NullString
+	err := row.Scan(&data, &expiresAt, &tag)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("key not found")
+		return nil, ErrKeyNotFound
 	} else if err != nil {
 		return nil, err
 	}
 
 	// Check expiration
-	if time.Now().Unix() > expiresAt {
+	if expiresAt != NeverExpires && time.Now().Unix() > expiresAt {
 		_ = r.Delete(key)
-		return nil, errors.New("key expired")
+		return nil, ErrKeyExpired
 	}
 
-	var value interface{}
-	if err := json.Unmarshal(jsonValue, &value); err != nil {
+	value, err := DecodeValue(r.serializer, key, tag.String, data)
+	if err != nil {
+		if r.DeleteCorrupt {
+			return nil, deleteCorrupt(r, key, err)
+		}
 		return nil, err
 	}
 
@@ -87,11 +194,11 @@ func (r *PostgresRepository) Get(key string) (*CacheEntry, error) {
 }
 
 func (r *PostgresRepository) Set(entry *CacheEntry) error {
-	jsonValue, err := json.Marshal(entry.Value)
+	tag, data, err := EncodeValue(r.serializer, entry.Value)
 	if err != nil {
 		return err
 	}
-	_, err = r.db.Exec(sqlInsertOrUpdateEntryPostgres, entry.Key, jsonValue, entry.ExpiresAt)
+	_, err = r.db.Exec(sqlInsertOrUpdateEntryPostgres, entry.Key, data, entry.ExpiresAt, tag)
 	return err
 }
 
@@ -105,30 +212,108 @@ func (r *PostgresRepository) Clear() error {
 	return err
 }
 
+// postgresUpsertBatchRows bounds the rows per multi-row upsert, keeping the
+// statement well below Postgres's limit of 65535 bind parameters.
+const postgresUpsertBatchRows = 1000
+
+// WriteBatch upserts entries with multi-row statements and deletes keys with a
+// single statement, in one transaction.
+func (r *PostgresRepository) WriteBatch(entries []*CacheEntry, deletes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(entries); start += postgresUpsertBatchRows {
+		end := start + postgresUpsertBatchRows
+		if end > len(entries) {
+			end = len(entries)
+		}
+		if err := r.upsertEntries(tx, entries[start:end]); err != nil {
+			return err
+		}
+	}
+
+	if len(deletes) > 0 {
+		if _, err := tx.Exec(sqlDeleteEntriesPostgres, pq.Array(deletes)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// upsertEntries writes entries with one multi-row INSERT ... ON CONFLICT.
+func (r *PostgresRepository) upsertEntries(tx *sql.Tx, entries []*CacheEntry) error {
+	var query strings.Builder
+	query.WriteString(sqlInsertOrUpdateEntriesPrefixPostgres)
+	args := make([]interface{}, 0, 4*len(entries))
+	for i, entry := range entries {
+		tag, data, err := EncodeValue(r.serializer, entry.Value)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d)", 4*i+1, 4*i+2, 4*i+3, 4*i+4)
+		args = append(args, entry.Key, data, entry.ExpiresAt, tag)
+	}
+	query.WriteString(sqlInsertOrUpdateEntriesSuffixPostgres)
+	_, err := tx.Exec(query.String(), args...)
+	return err
+}
+
+// Close closes the underlying database.
+func (r *PostgresRepository) Close() error {
+	return r.db.Close()
+}
+
 func (r *PostgresRepository) Paginate(offset, limit int) ([]*CacheEntry, error) {
+	for {
+		entries, corrupt, err := r.paginate(offset, limit)
+		if err != nil || len(corrupt) == 0 {
+			return entries, err
+		}
+		// Deleting the corrupt rows shifts later rows into this page, so read it again.
+		log.Printf("Deleting %d corrupt cache entries", len(corrupt))
+		if err := r.WriteBatch(nil, corrupt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// paginate reads a page of entries. With DeleteCorrupt set, the keys of
+// corrupt rows are returned instead of an error.
+func (r *PostgresRepository) paginate(offset, limit int) ([]*CacheEntry, []string, error) {
 	rows, err := r.db.Query(sqlPaginateEntriesPostgres, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var entries []*CacheEntry
+	var corrupt []string
 	for rows.Next() {
 		var key string
-		var jsonValue []byte
+		var data []byte
 		var expiresAt int64
+		var tag sql.NullString
 
-		if err := rows.Scan(&key, &jsonValue, &expiresAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&key, &data, &expiresAt, &tag); err != nil {
+			return nil, nil, err
 		}
 
-		var value interface{}
-		if err := json.Unmarshal(jsonValue, &value); err != nil {
-			return nil, err
+		value, err := DecodeValue(r.serializer, key, tag.String, data)
+		if r.DeleteCorrupt && errors.Is(err, ErrCorruptValue) {
+			corrupt = append(corrupt, key)
+			continue
+		} else if err != nil {
+			return nil, nil, err
 		}
 
 		entries = append(entries, &CacheEntry{Key: key, Value: value, ExpiresAt: expiresAt})
 	}
-	return entries, nil
+	return entries, corrupt, rows.Err()
 }
-
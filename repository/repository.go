@@ -3,11 +3,151 @@
 
 package repository
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"cachefy/serialization"
+)
+
+var (
+	// ErrKeyNotFound indicates that the repository holds no entry for a key.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExpired indicates that the repository entry for a key has expired.
+	ErrKeyExpired = errors.New("key expired")
+	// ErrUnknownTypeTag indicates that a stored value is tagged with a type
+	// name that is not registered in serialization.DefaultRegistry.
+	ErrUnknownTypeTag = errors.New("unknown type tag")
+	// ErrCorruptValue indicates that a stored value failed its integrity
+	// checks. It is matched by every CorruptValueError.
+	ErrCorruptValue = errors.New("corrupt value")
+	// ErrSerializerMismatch indicates that a stored value was written by a
+	// different serializer than the one reading it. It does not match
+	// ErrCorruptValue, so such values are never deleted as corrupt.
+	ErrSerializerMismatch = errors.New("value written by a different serializer")
+)
+
+// CorruptValueError reports a stored value that failed its integrity checks.
+type CorruptValueError struct {
+	Key string
+	Err error
+}
+
+func (e *CorruptValueError) Error() string {
+	return fmt.Sprintf("corrupt value for key %q: %v", e.Key, e.Err)
+}
+
+func (e *CorruptValueError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every CorruptValueError match ErrCorruptValue.
+func (e *CorruptValueError) Is(target error) bool {
+	return target == ErrCorruptValue
+}
+
 // CacheEntry represents a single cache entry in the repository.
 type CacheEntry struct {
 	Key       string      `json:"key"`        // Cache key
 	Value     interface{} `json:"value"`      // Cache value
-	ExpiresAt int64       `json:"expires_at"` // Expiration timestamp (Unix time)
+	ExpiresAt int64       `json:"expires_at"` // Expiration timestamp (Unix time), or NeverExpires
+}
+
+// NeverExpires is the ExpiresAt value of entries that never expire. Earlier
+// versions wrote an ExpiresAt of 0 on every row and treated it as expired, so
+// a zero ExpiresAt still means the entry has expired.
+const NeverExpires int64 = -1
+
+// ExpiresAtFor returns the ExpiresAt value for an entry with the given remaining
+// lifetime. A ttl <= 0, including interfaces.NoExpiration, yields NeverExpires.
+func ExpiresAtFor(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return NeverExpires
+	}
+	return time.Now().Add(ttl).Unix()
+}
+
+// Expired reports whether the entry has expired at now.
+func (e *CacheEntry) Expired(now time.Time) bool {
+	return e.ExpiresAt != NeverExpires && now.Unix() > e.ExpiresAt
+}
+
+// EncodeValue serializes value for storage, sealed in an envelope, and returns
+// the name its type is registered under in serialization.DefaultRegistry, or
+// "" if it has none. The repositories store values this way, as does anything
+// else that must restore them as their original types, such as the
+// write-behind overflow file. Registered values are marshalled as their
+// concrete type. Other values are marshalled through a pointer to their
+// interface so that type-aware serializers, such as gob, still record their
+// concrete type.
+func EncodeValue(serializer serialization.Serializer, value interface{}) (string, []byte, error) {
+	tag, ok := serialization.DefaultRegistry.Name(value)
+	var data []byte
+	var err error
+	if ok {
+		data, err = serializer.Marshal(value)
+	} else {
+		data, err = serializer.Marshal(&value)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return tag, serialization.SealEnvelope(serialization.SerializerID(serializer), data), nil
+}
+
+// DecodeValue restores the value encoded by EncodeValue for key. Envelopes that
+// fail their checks are reported as a CorruptValueError, and envelopes written
+// by another serializer as ErrSerializerMismatch; data without an envelope
+// predates envelopes and is decoded as it is. A value tagged with a
+// registered type name is decoded into that type; untagged values, including
+// rows written before type tags existed, are decoded generically.
+func DecodeValue(serializer serialization.Serializer, key, tag string, data []byte) (interface{}, error) {
+	if serialization.IsEnvelope(data) {
+		id, payload, err := serialization.OpenEnvelope(data)
+		if err != nil {
+			return nil, &CorruptValueError{Key: key, Err: err}
+		}
+		want := serialization.SerializerID(serializer)
+		if id != want && id != serialization.UnknownSerializerID && want != serialization.UnknownSerializerID {
+			return nil, fmt.Errorf("key %q: %w: serializer %d, reading with %d", key, ErrSerializerMismatch, id, want)
+		}
+		data = payload
+	}
+
+	if tag != "" {
+		if ptr, ok := serialization.DefaultRegistry.New(tag); ok {
+			if err := serializer.Unmarshal(data, ptr); err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(ptr).Elem().Interface(), nil
+		}
+	}
+
+	var value interface{}
+	if err := serializer.Unmarshal(data, &value); err != nil {
+		if tag != "" {
+			return nil, fmt.Errorf("%w %q: %w", ErrUnknownTypeTag, tag, err)
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// deleteCorrupt deletes the row for key from repo if err is a
+// CorruptValueError, so the key reads as not found from then on. Other errors
+// are returned unchanged.
+func deleteCorrupt(repo Repository, key string, err error) error {
+	if !errors.Is(err, ErrCorruptValue) {
+		return err
+	}
+	log.Printf("Deleting corrupt cache entry: %v", err)
+	if deleteErr := repo.Delete(key); deleteErr != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrKeyNotFound, err)
 }
 
 // Repository defines the interface for cache persistence operations.
@@ -17,4 +157,9 @@ type Repository interface {
 	Delete(key string) error
 	Clear() error
 	Paginate(offset, limit int) ([]*CacheEntry, error)
+	// WriteBatch atomically upserts entries and deletes keys. A key must not
+	// appear more than once across entries and deletes.
+	WriteBatch(entries []*CacheEntry, deletes []string) error
+	// Close releases the repository's database connections.
+	Close() error
 }
@@ -3,14 +3,24 @@ package repository
 import (
 	"database/sql"
 	"errors"
+	"log"
 	"time"
 
+	"cachefy/serialization"
+
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
 // SQLiteRepository is a repository implementation for SQLite.
 type SQLiteRepository struct {
-	db *sql.DB
+	db         *sql.DB
+	serializer serialization.Serializer
+
+	// DeleteCorrupt makes Get and Paginate delete rows whose values fail
+	// their integrity checks, rather than returning a CorruptValueError.
+	// Get then reports ErrKeyNotFound wrapped around the corruption error.
+	// It must be set before the repository is used.
+	DeleteCorrupt bool
 }
 
 // SQL statements as constants
@@ -19,18 +29,29 @@ const (
 	CREATE TABLE IF NOT EXISTS cache (
 		key TEXT PRIMARY KEY,
 		value BLOB,
-		expires_at INTEGER
+		expires_at INTEGER,
+		type_tag TEXT
 	)`
 
+	sqlHasTypeTagColumn = `
+	SELECT COUNT(*) FROM pragma_table_info('cache') WHERE name = 'type_tag'`
+
+	sqlAddTypeTagColumn = `
+	ALTER TABLE cache ADD COLUMN type_tag TEXT`
+
+	sqlDeleteLegacyEntries = `
+	DELETE FROM cache WHERE expires_at = 0`
+
 	sqlGetEntry = `
-	SELECT value, expires_at FROM cache WHERE key = ?`
+	SELECT value, expires_at, type_tag FROM cache WHERE key = ?`
 
 	sqlInsertOrUpdateEntry = `
-	INSERT INTO cache (key, value, expires_at)
-	VALUES (?, ?, ?)
+	INSERT INTO cache (key, value, expires_at, type_tag)
+	VALUES (?, ?, ?, ?)
 	ON CONFLICT(key) DO UPDATE SET
 		value = excluded.value,
-		expires_at = excluded.expires_at`
+		expires_at = excluded.expires_at,
+		type_tag = excluded.type_tag`
 
 	sqlDeleteEntry = `
 	DELETE FROM cache WHERE key = ?`
@@ -39,51 +60,90 @@ const (
 	DELETE FROM cache`
 
 	sqlPaginateEntries = `
-	SELECT key, value, expires_at FROM cache
+	SELECT key, value, expires_at, type_tag FROM cache
 	ORDER BY key ASC LIMIT ? OFFSET ?`
 )
 
 // NewSQLiteRepository creates a new repository instance connected to an SQLite database.
-func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
+// Values are stored as BLOBs encoded with serializer; a nil serializer selects
+// serialization.BlobSerializer, which restores values to their original types
+// as long as custom types are registered with gob.Register. Values whose types
+// are registered with serialization.Register are tagged with their type name
+// and decoded into that type with any serializer.
+func NewSQLiteRepository(dbPath string, serializer serialization.Serializer) (*SQLiteRepository, error) {
+	if serializer == nil {
+		serializer = &serialization.BlobSerializer{}
+	}
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, err
 	}
-	repo := &SQLiteRepository{db: db}
+	repo := &SQLiteRepository{db: db, serializer: serializer}
 	if err := repo.initTable(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return repo, nil
 }
 
 func (r *SQLiteRepository) initTable() error {
-	_, err := r.db.Exec(sqlCreateTable)
-	return err
+	if _, err := r.db.Exec(sqlCreateTable); err != nil {
+		return err
+	}
+
+	// Tables created before values were tagged lack the type_tag column. Their
+	// rows were all written with an ExpiresAt of 0, which those versions read
+	// as expired, and hold raw values no serializer decodes, so drop them.
+	var count int
+	if err := r.db.QueryRow(sqlHasTypeTagColumn).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		if _, err := r.db.Exec(sqlAddTypeTagColumn); err != nil {
+			return err
+		}
+		_, err := r.db.Exec(sqlDeleteLegacyEntries)
+		return err
+	}
+	return nil
 }
 
 func (r *SQLiteRepository) Get(key string) (*CacheEntry, error) {
 	row := r.db.QueryRow(sqlGetEntry, key)
 
-	var value []byte
+	var data []byte
 	var expiresAt int64
-	err := row.Scan(&value, &expiresAt)
+	var tag sql.NullString
+	err := row.Scan(&data, &expiresAt, &tag)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("key not found")
+		return nil, ErrKeyNotFound
 	} else if err != nil {
 		return nil, err
 	}
 
 	// Check expiration
-	if time.Now().Unix() > expiresAt {
+	if expiresAt != NeverExpires && time.Now().Unix() > expiresAt {
 		_ = r.Delete(key) // Automatically clean up expired entries
-		return nil, errors.New("key expired")
+		return nil, ErrKeyExpired
+	}
+
+	value, err := DecodeValue(r.serializer, key, tag.String, data)
+	if err != nil {
+		if r.DeleteCorrupt {
+			return nil, deleteCorrupt(r, key, err)
+		}
+		return nil, err
 	}
 
 	return &CacheEntry{Key: key, Value: value, ExpiresAt: expiresAt}, nil
 }
 
 func (r *SQLiteRepository) Set(entry *CacheEntry) error {
-	_, err := r.db.Exec(sqlInsertOrUpdateEntry, entry.Key, entry.Value, entry.ExpiresAt)
+	tag, data, err := EncodeValue(r.serializer, entry.Value)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(sqlInsertOrUpdateEntry, entry.Key, data, entry.ExpiresAt, tag)
 	return err
 }
 
@@ -97,25 +157,97 @@ func (r *SQLiteRepository) Clear() error {
 	return err
 }
 
+// WriteBatch upserts entries and deletes keys in a single transaction.
+func (r *SQLiteRepository) WriteBatch(entries []*CacheEntry, deletes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(entries) > 0 {
+		stmt, err := tx.Prepare(sqlInsertOrUpdateEntry)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, entry := range entries {
+			tag, data, err := EncodeValue(r.serializer, entry.Value)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(entry.Key, data, entry.ExpiresAt, tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(deletes) > 0 {
+		stmt, err := tx.Prepare(sqlDeleteEntry)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, key := range deletes {
+			if _, err := stmt.Exec(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the underlying database.
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
+
 func (r *SQLiteRepository) Paginate(offset, limit int) ([]*CacheEntry, error) {
+	for {
+		entries, corrupt, err := r.paginate(offset, limit)
+		if err != nil || len(corrupt) == 0 {
+			return entries, err
+		}
+		// Deleting the corrupt rows shifts later rows into this page, so read it again.
+		log.Printf("Deleting %d corrupt cache entries", len(corrupt))
+		if err := r.WriteBatch(nil, corrupt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// paginate reads a page of entries. With DeleteCorrupt set, the keys of
+// corrupt rows are returned instead of an error.
+func (r *SQLiteRepository) paginate(offset, limit int) ([]*CacheEntry, []string, error) {
 	rows, err := r.db.Query(sqlPaginateEntries, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var entries []*CacheEntry
+	var corrupt []string
 	for rows.Next() {
 		var key string
-		var value []byte
+		var data []byte
 		var expiresAt int64
+		var tag sql.NullString
 
-		if err := rows.Scan(&key, &value, &expiresAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&key, &data, &expiresAt, &tag); err != nil {
+			return nil, nil, err
+		}
+
+		value, err := DecodeValue(r.serializer, key, tag.String, data)
+		if r.DeleteCorrupt && errors.Is(err, ErrCorruptValue) {
+			corrupt = append(corrupt, key)
+			continue
+		} else if err != nil {
+			return nil, nil, err
 		}
 
 		entries = append(entries, &CacheEntry{Key: key, Value: value, ExpiresAt: expiresAt})
 	}
-	return entries, nil
+	return entries, corrupt, rows.Err()
 }
 
@@ -1,9 +1,12 @@
-
 // File: sqlite_repository_test.go
 
 package repository
 
 import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
@@ -35,3 +38,163 @@ func TestSQLiteRepository(t *testing.T) {
 		t.Fatalf("Failed to get cache entry: %v", err)
 	}
 }
+
+type sqliteTestValue struct {
+	Name  string
+	Count int
+}
+
+func TestSQLiteRepositoryRoundTrip(t *testing.T) {
+	if err := serialization.Register("repository.sqliteTestValue", sqliteTestValue{}); err != nil {
+		t.Fatalf("Failed to register type: %v", err)
+	}
+
+	serializers := map[string]serialization.Serializer{
+		"gob":     &serialization.BlobSerializer{},
+		"json":    &serialization.JSONSerializer{},
+		"msgpack": &serialization.MsgPackSerializer{},
+		"cbor":    &serialization.CBORSerializer{},
+	}
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			testSQLiteRepositoryRoundTrip(t, serializer)
+		})
+	}
+}
+
+func testSQLiteRepositoryRoundTrip(t *testing.T, serializer serialization.Serializer) {
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "cache.db"), serializer)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite repository: %v", err)
+	}
+	defer repo.Close()
+
+	values := map[string]interface{}{
+		"int":    42,
+		"float":  1.5,
+		"slice":  []string{"a", "b"},
+		"struct": sqliteTestValue{Name: "widget", Count: 3},
+	}
+
+	// Test that Set and Get restore the original types
+	for key, value := range values {
+		if err := repo.Set(&CacheEntry{Key: key, Value: value, ExpiresAt: NeverExpires}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+		retrieved, err := repo.Get(key)
+		if err != nil || !reflect.DeepEqual(retrieved.Value, value) {
+			t.Errorf("Expected %#v for %s, got %#v, error: %v", value, key, retrieved, err)
+		}
+	}
+
+	// Test that WriteBatch and Paginate restore the original types
+	repo.Clear()
+	var entries []*CacheEntry
+	for key, value := range values {
+		entries = append(entries, &CacheEntry{Key: key, Value: value, ExpiresAt: NeverExpires})
+	}
+	if err := repo.WriteBatch(entries, nil); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	page, err := repo.Paginate(0, len(values))
+	if err != nil || len(page) != len(values) {
+		t.Fatalf("Expected %d entries, got %d, error: %v", len(values), len(page), err)
+	}
+	for _, entry := range page {
+		if !reflect.DeepEqual(entry.Value, values[entry.Key]) {
+			t.Errorf("Expected %#v for %s, got %#v", values[entry.Key], entry.Key, entry.Value)
+		}
+	}
+}
+
+func TestSQLiteRepositoryTypeTagMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE cache (key TEXT PRIMARY KEY, value BLOB, expires_at INTEGER)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO cache (key, value, expires_at) VALUES ('legacy', '"old"', 0)`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+
+	// Test that a table without a type_tag column is migrated, dropping its
+	// rows, which were all written already expired
+	repo, err := NewSQLiteRepository(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	defer repo.Close()
+
+	if entry, err := repo.Get("legacy"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v, error: %v", entry, err)
+	}
+	if entries, err := repo.Paginate(0, 10); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries, got %v, error: %v", entries, err)
+	}
+	if err := repo.Set(&CacheEntry{Key: "new", Value: 7, ExpiresAt: NeverExpires}); err != nil {
+		t.Fatalf("Failed to set entry: %v", err)
+	}
+	entry, err := repo.Get("new")
+	if err != nil || entry.Value != 7 {
+		t.Errorf("Expected int 7, got %#v, error: %v", entry, err)
+	}
+}
+
+func TestSQLiteRepositoryCorruptValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	repo, err := NewSQLiteRepository(path, &serialization.JSONSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to create SQLite repository: %v", err)
+	}
+	defer repo.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := repo.Set(&CacheEntry{Key: key, Value: "value-" + key, ExpiresAt: NeverExpires}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+	// Flip the last byte of the stored value of "b"
+	if _, err := repo.db.Exec(`UPDATE cache SET value = substr(value, 1, length(value) - 1) || X'00' WHERE key = 'b'`); err != nil {
+		t.Fatalf("Failed to corrupt entry: %v", err)
+	}
+
+	// Test that a corrupt value is reported as a CorruptValueError
+	_, err = repo.Get("b")
+	var corruptErr *CorruptValueError
+	if !errors.As(err, &corruptErr) || corruptErr.Key != "b" || !errors.Is(err, ErrCorruptValue) {
+		t.Errorf("Expected CorruptValueError for key b, got %v", err)
+	}
+	if _, err := repo.Paginate(0, 10); !errors.Is(err, ErrCorruptValue) {
+		t.Errorf("Expected Paginate to report ErrCorruptValue, got %v", err)
+	}
+
+	// Test that a different serializer is reported as a mismatch, not as corruption
+	other, err := NewSQLiteRepository(path, &serialization.MsgPackSerializer{})
+	if err != nil {
+		t.Fatalf("Failed to open SQLite repository: %v", err)
+	}
+	other.DeleteCorrupt = true
+	_, err = other.Get("a")
+	other.Close()
+	if !errors.Is(err, ErrSerializerMismatch) || errors.Is(err, ErrCorruptValue) {
+		t.Errorf("Expected ErrSerializerMismatch, got %v", err)
+	}
+	if entry, err := repo.Get("a"); err != nil || entry.Value != "value-a" {
+		t.Errorf("Expected mismatched entry to be kept, got %v, error: %v", entry, err)
+	}
+
+	// Test that DeleteCorrupt skips and deletes corrupt rows in Paginate
+	repo.DeleteCorrupt = true
+	entries, err := repo.Paginate(0, 2)
+	if err != nil || len(entries) != 2 || entries[0].Key != "a" || entries[1].Key != "c" {
+		t.Errorf("Expected entries a and c, got %v, error: %v", entries, err)
+	}
+	if _, err := repo.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected corrupt entry to be deleted, got %v", err)
+	}
+}
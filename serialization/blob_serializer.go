@@ -25,3 +25,8 @@ func (s *BlobSerializer) Unmarshal(data []byte, value interface{}) error {
 	decoder := gob.NewDecoder(buf)
 	return decoder.Decode(value)
 }
+
+// SerializerID returns GobSerializerID.
+func (s *BlobSerializer) SerializerID() byte {
+	return GobSerializerID
+}
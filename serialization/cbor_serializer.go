@@ -0,0 +1,34 @@
+// File: cbor_serializer.go
+
+package serialization
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborDecMode decodes maps held in an interface{} as map[string]interface{},
+// as with JSON, rather than the CBOR default of map[interface{}]interface{}.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+// CBORSerializer serializes objects into CBOR (RFC 8949), a compact binary
+// format with libraries for most languages.
+type CBORSerializer struct{}
+
+// Marshal converts an object into CBOR.
+func (s *CBORSerializer) Marshal(value interface{}) ([]byte, error) {
+	return cbor.Marshal(value)
+}
+
+// Unmarshal converts CBOR data into an object.
+func (s *CBORSerializer) Unmarshal(data []byte, value interface{}) error {
+	return cborDecMode.Unmarshal(data, value)
+}
+
+// SerializerID returns CBORSerializerID.
+func (s *CBORSerializer) SerializerID() byte {
+	return CBORSerializerID
+}
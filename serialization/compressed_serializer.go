@@ -0,0 +1,198 @@
+// File: compressed_serializer.go
+
+package serialization
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrUnknownCodec is returned when a compressed payload names a codec that is
+// not registered.
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+// Codec compresses serialized payloads. Every codec has a name, used to select
+// it in configuration, and an ID, recorded in each payload it compresses.
+type Codec interface {
+	Name() string
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// compressedHeader starts every compressed payload and is followed by the
+// codec ID. No serializer produces a single value that starts with a zero
+// byte followed by more data, so payloads stored without compression, including
+// rows written before compression was enabled, are told apart by its absence.
+var compressedHeader = []byte{0x00, 'C', 'Z'}
+
+var (
+	codecMutex sync.RWMutex
+	codecsByID = make(map[byte]Codec)
+	codecNames = make(map[string]Codec)
+)
+
+func init() {
+	RegisterCodec(&GzipCodec{Level: gzip.DefaultCompression})
+	RegisterCodec(&FlateCodec{Level: flate.DefaultCompression})
+}
+
+// RegisterCodec makes codec available by name and for decompression, such as
+// a snappy or zstd codec. A codec registered later replaces one with the same
+// name or ID.
+func RegisterCodec(codec Codec) {
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+
+	codecsByID[codec.ID()] = codec
+	codecNames[codec.Name()] = codec
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (Codec, bool) {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+
+	codec, ok := codecNames[name]
+	return codec, ok
+}
+
+// GzipCodec compresses with gzip. Its name is "gzip" and its ID is 1.
+type GzipCodec struct {
+	Level int
+}
+
+func (c *GzipCodec) Name() string { return "gzip" }
+func (c *GzipCodec) ID() byte     { return 1 }
+
+func (c *GzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GzipCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// FlateCodec compresses with raw DEFLATE. Its name is "flate" and its ID is 2.
+type FlateCodec struct {
+	Level int
+}
+
+func (c *FlateCodec) Name() string { return "flate" }
+func (c *FlateCodec) ID() byte     { return 2 }
+
+func (c *FlateCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *FlateCodec) Decompress(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// DefaultCompressionThreshold is the payload size from which
+// CompressedSerializer compresses when no threshold is given.
+const DefaultCompressionThreshold = 1024
+
+// CompressedSerializer wraps another Serializer and compresses its payloads
+// of at least Threshold bytes with Codec. Smaller payloads, and payloads that
+// do not shrink, are stored as the wrapped serializer produced them.
+// Compressed payloads are decompressed with whichever registered codec
+// compressed them, so the codec can be changed without rewriting old rows.
+type CompressedSerializer struct {
+	serializer Serializer
+	codec      Codec
+	threshold  int
+}
+
+// NewCompressedSerializer wraps serializer. A nil codec selects gzip and a
+// threshold <= 0 selects DefaultCompressionThreshold.
+func NewCompressedSerializer(serializer Serializer, codec Codec, threshold int) *CompressedSerializer {
+	if codec == nil {
+		codec, _ = LookupCodec("gzip")
+	}
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	return &CompressedSerializer{serializer: serializer, codec: codec, threshold: threshold}
+}
+
+// Marshal serializes value and compresses the result if it is large enough.
+func (s *CompressedSerializer) Marshal(value interface{}) ([]byte, error) {
+	data, err := s.serializer.Marshal(value)
+	if err != nil || len(data) < s.threshold {
+		return data, err
+	}
+
+	compressed, err := s.codec.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressedHeader)+1+len(compressed) >= len(data) {
+		return data, nil
+	}
+
+	payload := make([]byte, 0, len(compressedHeader)+1+len(compressed))
+	payload = append(payload, compressedHeader...)
+	payload = append(payload, s.codec.ID())
+	return append(payload, compressed...), nil
+}
+
+// Unmarshal decompresses data if it is compressed and deserializes it.
+func (s *CompressedSerializer) Unmarshal(data []byte, value interface{}) error {
+	if len(data) > len(compressedHeader) && bytes.HasPrefix(data, compressedHeader) {
+		id := data[len(compressedHeader)]
+
+		codecMutex.RLock()
+		codec, ok := codecsByID[id]
+		codecMutex.RUnlock()
+		if !ok {
+			return fmt.Errorf("%w: id %d", ErrUnknownCodec, id)
+		}
+
+		decompressed, err := codec.Decompress(data[len(compressedHeader)+1:])
+		if err != nil {
+			return err
+		}
+		data = decompressed
+	}
+	return s.serializer.Unmarshal(data, value)
+}
+
+// SerializerID returns the ID of the wrapped serializer.
+func (s *CompressedSerializer) SerializerID() byte {
+	return SerializerID(s.serializer)
+}
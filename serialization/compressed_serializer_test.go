@@ -0,0 +1,65 @@
+// File: compressed_serializer_test.go
+
+package serialization
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompressedSerializer(t *testing.T) {
+	large := strings.Repeat("cachefy ", 512)
+
+	for _, name := range []string{"gzip", "flate"} {
+		codec, ok := LookupCodec(name)
+		if !ok {
+			t.Fatalf("Expected codec %s to be registered", name)
+		}
+		serializer := NewCompressedSerializer(&JSONSerializer{}, codec, 64)
+
+		// Test that large payloads are compressed and restored
+		data, err := serializer.Marshal(large)
+		if err != nil || !bytes.HasPrefix(data, compressedHeader) || len(data) >= len(large) {
+			t.Errorf("Expected compressed %s payload, got %d bytes, error: %v", name, len(data), err)
+		}
+		var value string
+		if err := serializer.Unmarshal(data, &value); err != nil || value != large {
+			t.Errorf("Expected large value to round-trip with %s, error: %v", name, err)
+		}
+
+		// Test that payloads below the threshold are stored as is
+		data, err = serializer.Marshal("small")
+		if err != nil || string(data) != `"small"` {
+			t.Errorf("Expected uncompressed payload, got %q, error: %v", data, err)
+		}
+	}
+}
+
+func TestCompressedSerializerMixedRows(t *testing.T) {
+	plain := &JSONSerializer{}
+	gzipCodec, _ := LookupCodec("gzip")
+	flateCodec, _ := LookupCodec("flate")
+	large := strings.Repeat("x", 4096)
+
+	legacy, _ := plain.Marshal(large)
+	gzipped, _ := NewCompressedSerializer(plain, gzipCodec, 1).Marshal(large)
+	flated, _ := NewCompressedSerializer(plain, flateCodec, 1).Marshal(large)
+
+	// Test that uncompressed rows and rows from another codec still decode
+	serializer := NewCompressedSerializer(plain, gzipCodec, 1)
+	for _, data := range [][]byte{legacy, gzipped, flated} {
+		var value string
+		if err := serializer.Unmarshal(data, &value); err != nil || value != large {
+			t.Errorf("Expected mixed row to decode, error: %v", err)
+		}
+	}
+
+	// Test that an unregistered codec ID is reported
+	unknown := append(append([]byte{}, compressedHeader...), 0xFF, 0x01)
+	var value string
+	if err := serializer.Unmarshal(unknown, &value); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("Expected ErrUnknownCodec, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+// File: encrypted_serializer.go
+
+package serialization
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownKey is returned when an encrypted payload names a key ID the
+	// serializer does not hold.
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrNotEncrypted is returned when a payload read by an
+	// EncryptedSerializer is not encrypted, such as one stored before
+	// encryption was enabled, unless ReadPlaintext is set.
+	ErrNotEncrypted = errors.New("payload is not encrypted")
+)
+
+// encryptedHeader starts every encrypted payload. It is followed by the length
+// of the key ID, the key ID, the nonce and the AES-GCM ciphertext. The header
+// and key ID are authenticated along with the ciphertext.
+var encryptedHeader = []byte{0x00, 'E', 'N'}
+
+// EncryptedSerializer wraps another Serializer and encrypts its payloads with
+// AES-GCM. Every payload records the ID of the key that encrypted it, so keys
+// can be rotated: new payloads are encrypted with the current key, while
+// payloads encrypted with older keys decrypt as long as those keys are kept.
+type EncryptedSerializer struct {
+	serializer Serializer
+	currentID  string
+	keys       map[string]cipher.AEAD
+
+	// ReadPlaintext makes Unmarshal read payloads that are not encrypted with
+	// the wrapped serializer, so that values stored before encryption was
+	// enabled stay readable until they are rewritten, encrypted. Such payloads
+	// are not authenticated. It must be set before the serializer is used.
+	ReadPlaintext bool
+}
+
+// NewEncryptedSerializer wraps serializer. keys maps key IDs to AES keys of
+// 16, 24 or 32 bytes, and currentID selects the key that encrypts new payloads.
+func NewEncryptedSerializer(serializer Serializer, currentID string, keys map[string][]byte) (*EncryptedSerializer, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, currentID)
+	}
+
+	s := &EncryptedSerializer{
+		serializer: serializer,
+		currentID:  currentID,
+		keys:       make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("key ID %q must be 1 to 255 bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		s.keys[id] = aead
+	}
+	return s, nil
+}
+
+// Marshal serializes value and encrypts the result with the current key.
+func (s *EncryptedSerializer) Marshal(value interface{}) ([]byte, error) {
+	data, err := s.serializer.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := s.keys[s.currentID]
+	prefix := make([]byte, 0, len(encryptedHeader)+1+len(s.currentID))
+	prefix = append(prefix, encryptedHeader...)
+	prefix = append(prefix, byte(len(s.currentID)))
+	prefix = append(prefix, s.currentID...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, 0, len(prefix)+len(nonce)+len(data)+aead.Overhead())
+	payload = append(payload, prefix...)
+	payload = append(payload, nonce...)
+	return aead.Seal(payload, nonce, data, prefix), nil
+}
+
+// Unmarshal decrypts data with the key it names and deserializes it.
+func (s *EncryptedSerializer) Unmarshal(data []byte, value interface{}) error {
+	if len(data) <= len(encryptedHeader) || !bytes.HasPrefix(data, encryptedHeader) {
+		if s.ReadPlaintext {
+			return s.serializer.Unmarshal(data, value)
+		}
+		return ErrNotEncrypted
+	}
+
+	idEnd := len(encryptedHeader) + 1 + int(data[len(encryptedHeader)])
+	if idEnd > len(data) {
+		return ErrNotEncrypted
+	}
+	id := string(data[len(encryptedHeader)+1 : idEnd])
+	aead, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	if len(data) < idEnd+aead.NonceSize() {
+		return ErrNotEncrypted
+	}
+
+	nonce := data[idEnd : idEnd+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[idEnd+aead.NonceSize():], data[:idEnd])
+	if err != nil {
+		return fmt.Errorf("decrypting with key %q: %w", id, err)
+	}
+	return s.serializer.Unmarshal(plaintext, value)
+}
+
+// SerializerID returns the ID of the wrapped serializer.
+func (s *EncryptedSerializer) SerializerID() byte {
+	return SerializerID(s.serializer)
+}
@@ -0,0 +1,67 @@
+// File: encrypted_serializer_test.go
+
+package serialization
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptedSerializer(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	serializer, err := NewEncryptedSerializer(&JSONSerializer{}, "2024-01", map[string][]byte{"2024-01": oldKey})
+	if err != nil {
+		t.Fatalf("Failed to create encrypted serializer: %v", err)
+	}
+
+	// Test that values round-trip and are not stored in plaintext
+	data, err := serializer.Marshal("secret-value")
+	if err != nil || bytes.Contains(data, []byte("secret-value")) {
+		t.Errorf("Expected encrypted payload, got %q, error: %v", data, err)
+	}
+	var value string
+	if err := serializer.Unmarshal(data, &value); err != nil || value != "secret-value" {
+		t.Errorf("Expected 'secret-value', got %q, error: %v", value, err)
+	}
+
+	// Test that a rotated serializer decrypts old payloads and encrypts with the new key
+	rotated, err := NewEncryptedSerializer(&JSONSerializer{}, "2024-06", map[string][]byte{"2024-01": oldKey, "2024-06": newKey})
+	if err != nil {
+		t.Fatalf("Failed to create rotated serializer: %v", err)
+	}
+	value = ""
+	if err := rotated.Unmarshal(data, &value); err != nil || value != "secret-value" {
+		t.Errorf("Expected old payload to decrypt after rotation, got %q, error: %v", value, err)
+	}
+	rewritten, _ := rotated.Marshal("secret-value")
+	if err := serializer.Unmarshal(rewritten, &value); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for the new key, got %v", err)
+	}
+
+	// Test that tampered and plaintext payloads are rejected
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 0xFF
+	if err := serializer.Unmarshal(tampered, &value); err == nil {
+		t.Errorf("Expected tampered payload to be rejected")
+	}
+	if err := serializer.Unmarshal([]byte(`"plain"`), &value); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+
+	// Test that ReadPlaintext reads payloads stored before encryption was enabled
+	rotated.ReadPlaintext = true
+	if err := rotated.Unmarshal([]byte(`"plain"`), &value); err != nil || value != "plain" {
+		t.Errorf("Expected plain, got %q, error: %v", value, err)
+	}
+	if err := rotated.Unmarshal(tampered, &value); err == nil {
+		t.Errorf("Expected tampered payload to be rejected with ReadPlaintext")
+	}
+
+	// Test that the current key must be one of the keys
+	if _, err := NewEncryptedSerializer(&JSONSerializer{}, "missing", map[string][]byte{"2024-01": oldKey}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for a missing current key, got %v", err)
+	}
+}
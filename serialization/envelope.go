@@ -0,0 +1,83 @@
+// File: envelope.go
+
+package serialization
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrCorruptEnvelope is returned when an envelope fails its integrity checks.
+var ErrCorruptEnvelope = errors.New("corrupt value envelope")
+
+// IDs of the built-in serializers, recorded in envelopes.
+const (
+	UnknownSerializerID byte = 0
+	GobSerializerID     byte = 1
+	JSONSerializerID    byte = 2
+	MsgPackSerializerID byte = 3
+	CBORSerializerID    byte = 4
+)
+
+// EnvelopeVersion is the envelope format written by SealEnvelope.
+const EnvelopeVersion byte = 1
+
+// envelopeMagic starts every envelope. Envelopes are laid out as the magic,
+// the format version, the serializer ID, a big-endian CRC32 (IEEE) of the
+// version, serializer ID and payload, and the payload itself.
+var envelopeMagic = []byte{0xCA, 'C', 'F', 'Y'}
+
+const envelopeHeaderSize = 4 + 1 + 1 + 4
+
+// SerializerID returns the ID recorded in envelopes for serializer. Decorators
+// such as CompressedSerializer report the ID of the serializer they wrap.
+// Serializers outside this package report UnknownSerializerID unless they
+// have a SerializerID() byte method.
+func SerializerID(serializer Serializer) byte {
+	if identified, ok := serializer.(interface{ SerializerID() byte }); ok {
+		return identified.SerializerID()
+	}
+	return UnknownSerializerID
+}
+
+// SealEnvelope wraps payload in an envelope recording serializerID.
+func SealEnvelope(serializerID byte, payload []byte) []byte {
+	data := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(payload))
+	copy(data, envelopeMagic)
+	data[4] = EnvelopeVersion
+	data[5] = serializerID
+	data = append(data, payload...)
+	binary.BigEndian.PutUint32(data[6:10], envelopeChecksum(data))
+	return data
+}
+
+// IsEnvelope reports whether data starts like an envelope. Data without an
+// envelope predates envelopes and should be decoded as it is.
+func IsEnvelope(data []byte) bool {
+	return len(data) >= envelopeHeaderSize && bytes.HasPrefix(data, envelopeMagic)
+}
+
+// OpenEnvelope verifies an envelope and returns its serializer ID and payload.
+// Failed checks are reported as ErrCorruptEnvelope.
+func OpenEnvelope(data []byte) (byte, []byte, error) {
+	if !IsEnvelope(data) {
+		return 0, nil, fmt.Errorf("%w: missing header", ErrCorruptEnvelope)
+	}
+	if data[4] != EnvelopeVersion {
+		return 0, nil, fmt.Errorf("%w: unsupported version %d", ErrCorruptEnvelope, data[4])
+	}
+	if binary.BigEndian.Uint32(data[6:10]) != envelopeChecksum(data) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptEnvelope)
+	}
+	return data[5], data[envelopeHeaderSize:], nil
+}
+
+// envelopeChecksum computes the checksum of an envelope, skipping the magic
+// and the checksum itself.
+func envelopeChecksum(data []byte) uint32 {
+	checksum := crc32.ChecksumIEEE(data[4:6])
+	return crc32.Update(checksum, crc32.IEEETable, data[envelopeHeaderSize:])
+}
@@ -0,0 +1,45 @@
+// File: envelope_test.go
+
+package serialization
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	payload := []byte(`{"name":"widget"}`)
+	data := SealEnvelope(JSONSerializerID, payload)
+
+	// Test that a sealed envelope opens to its serializer ID and payload
+	id, opened, err := OpenEnvelope(data)
+	if err != nil || id != JSONSerializerID || !bytes.Equal(opened, payload) {
+		t.Errorf("Expected JSON payload %q, got id %d and %q, error: %v", payload, id, opened, err)
+	}
+
+	// Test that a flipped byte fails the checksum
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-2] ^= 0x01
+	if _, _, err := OpenEnvelope(corrupted); !errors.Is(err, ErrCorruptEnvelope) {
+		t.Errorf("Expected ErrCorruptEnvelope for a flipped byte, got %v", err)
+	}
+
+	// Test that an unknown version is rejected
+	future := append([]byte{}, data...)
+	future[4] = EnvelopeVersion + 1
+	if _, _, err := OpenEnvelope(future); !errors.Is(err, ErrCorruptEnvelope) {
+		t.Errorf("Expected ErrCorruptEnvelope for an unknown version, got %v", err)
+	}
+
+	// Test that payloads without an envelope are recognised
+	if IsEnvelope(payload) {
+		t.Errorf("Expected plain payload not to be an envelope")
+	}
+
+	// Test that decorators report the ID of the serializer they wrap
+	compressed := NewCompressedSerializer(&MsgPackSerializer{}, nil, 0)
+	if id := SerializerID(compressed); id != MsgPackSerializerID {
+		t.Errorf("Expected MsgPackSerializerID, got %d", id)
+	}
+}
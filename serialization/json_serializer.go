@@ -19,3 +19,8 @@ func (s *JSONSerializer) Marshal(value interface{}) ([]byte, error) {
 func (s *JSONSerializer) Unmarshal(data []byte, value interface{}) error {
 	return json.Unmarshal(data, value)
 }
+
+// SerializerID returns JSONSerializerID.
+func (s *JSONSerializer) SerializerID() byte {
+	return JSONSerializerID
+}
@@ -0,0 +1,26 @@
+// File: msgpack_serializer.go
+
+package serialization
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgPackSerializer serializes objects into MessagePack, a compact binary
+// format with libraries for most languages.
+type MsgPackSerializer struct{}
+
+// Marshal converts an object into MessagePack.
+func (s *MsgPackSerializer) Marshal(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+// Unmarshal converts MessagePack data into an object.
+func (s *MsgPackSerializer) Unmarshal(data []byte, value interface{}) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+// SerializerID returns MsgPackSerializerID.
+func (s *MsgPackSerializer) SerializerID() byte {
+	return MsgPackSerializerID
+}
@@ -0,0 +1,106 @@
+// File: registry.go
+
+package serialization
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrTypeNameConflict is returned when a name is registered for two different types.
+var ErrTypeNameConflict = errors.New("type name already registered for a different type")
+
+// Registry maps type names to Go types, so that a stored value can be tagged
+// with its type name and later decoded into the same concrete type.
+type Registry struct {
+	mutex  sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		byName: make(map[string]reflect.Type),
+		byType: make(map[reflect.Type]string),
+	}
+}
+
+// DefaultRegistry is the registry used by the repositories. It comes with the
+// basic Go types registered under their Go names, such as "int" and "string".
+var DefaultRegistry = newDefaultRegistry()
+
+func newDefaultRegistry() *Registry {
+	registry := NewRegistry()
+	for _, value := range []interface{}{
+		false, "",
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+		[]byte(nil), []string(nil), []int(nil), []float64(nil), []interface{}(nil),
+		map[string]string(nil), map[string]interface{}(nil),
+		time.Time{},
+	} {
+		registry.Register(reflect.TypeOf(value).String(), value)
+	}
+	return registry
+}
+
+// Register records the type of value under name in DefaultRegistry.
+func Register(name string, value interface{}) error {
+	return DefaultRegistry.Register(name, value)
+}
+
+// Register records the type of value under name. Registering the same type
+// under the same name again is a no-op; a type registered under a second
+// name keeps the first one for tagging.
+func (r *Registry) Register(name string, value interface{}) error {
+	typ := reflect.TypeOf(value)
+	if typ == nil {
+		return errors.New("cannot register the type of a nil value")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, ok := r.byName[name]; ok {
+		if existing != typ {
+			return fmt.Errorf("%w: %q is %v", ErrTypeNameConflict, name, existing)
+		}
+		return nil
+	}
+	r.byName[name] = typ
+	if _, ok := r.byType[typ]; !ok {
+		r.byType[typ] = name
+	}
+	return nil
+}
+
+// Name returns the name registered for the type of value.
+func (r *Registry) Name(value interface{}) (string, bool) {
+	typ := reflect.TypeOf(value)
+	if typ == nil {
+		return "", false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	name, ok := r.byType[typ]
+	return name, ok
+}
+
+// New returns a pointer to a new zero value of the type registered under name.
+func (r *Registry) New(name string) (interface{}, bool) {
+	r.mutex.RLock()
+	typ, ok := r.byName[name]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(typ).Interface(), true
+}
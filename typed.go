@@ -0,0 +1,210 @@
+// File: typed.go
+
+package cachefy
+
+import (
+	"cachefy/backends/inmemory"
+	"cachefy/interfaces"
+	"errors"
+	"time"
+)
+
+// ErrTypeMismatch indicates that a cached value is not of the type expected by a typed cache.
+var ErrTypeMismatch = errors.New("cached value has unexpected type")
+
+// Cache is the type-safe counterpart of interfaces.Cache for keys of type K
+// and values of type V. Cache[string, interface{}] has the same method set as
+// interfaces.Cache, so the two are interchangeable.
+type Cache[K comparable, V any] interface {
+	Get(key K) (V, error)
+	Set(key K, value V) error
+	Delete(key K) error
+	Clear() error
+	SetWithTTL(key K, value V, ttl time.Duration) error
+	TTL(key K) (time.Duration, error)
+	Touch(key K, ttl time.Duration) error
+	Persist(key K) error
+	Close() error
+}
+
+// janitorStarter is implemented by the in-memory backends that can reclaim
+// expired entries in the background.
+type janitorStarter interface {
+	StartJanitor(interval time.Duration)
+}
+
+// NewTypedCache creates a Cache for keys of type K and values of type V.
+// In-memory backends store K and V directly without boxing. When persistence
+// is enabled K must be string, since repositories are keyed by string, and the
+// cache is built by NewCache and adapted with Typed.
+func NewTypedCache[K comparable, V any](config CacheConfig) (Cache[K, V], error) {
+	if !config.EnablePersistence {
+		return newBackend[K, V](config)
+	}
+
+	var zero K
+	if _, ok := interface{}(zero).(string); !ok {
+		return nil, errors.New("persistence requires string keys")
+	}
+	cache, err := NewCache(config)
+	if err != nil {
+		return nil, err
+	}
+	return interface{}(Typed[V](cache)).(Cache[K, V]), nil
+}
+
+// newBackend validates config and creates the configured in-memory backend.
+func newBackend[K comparable, V any](config CacheConfig) (Cache[K, V], error) {
+	// Validate config
+	if config.DefaultTTL <= 0 {
+		return nil, errors.New("defaultTTL must be greater than zero")
+	}
+
+	var cache Cache[K, V]
+	switch config.Backend {
+	case "syncmap":
+		cache = inmemory.NewTypedSyncMapCache[K, V](config.DefaultTTL)
+	case "rwmutex":
+		cache = inmemory.NewTypedRWMutexCache[K, V](config.DefaultTTL)
+	case "sharded":
+		if config.Shards <= 0 || config.ShardCapacity <= 0 {
+			return nil, errors.New("shards and shardCapacity must be greater than zero")
+		}
+		newPolicy, err := inmemory.NewTypedEvictionPolicyFactory[K](config.EvictionPolicy)
+		if err != nil {
+			return nil, err
+		}
+		cache = inmemory.NewTypedShardedCacheWithPolicy[K, V](config.Shards, config.DefaultTTL, config.ShardCapacity, newPolicy)
+	case "tinylfu":
+		if config.Capacity <= 0 {
+			return nil, errors.New("capacity must be greater than zero")
+		}
+		cache = inmemory.NewTypedTinyLFUCache[K, V](config.DefaultTTL, config.Capacity)
+	default:
+		return nil, errors.New("unsupported backend")
+	}
+
+	if config.CleanupInterval > 0 {
+		cache.(janitorStarter).StartJanitor(config.CleanupInterval)
+	}
+	return cache, nil
+}
+
+// Typed adapts an untyped cache to Cache[string, V]. Reading a value that is
+// not a V returns ErrTypeMismatch.
+func Typed[V any](cache interfaces.Cache) Cache[string, V] {
+	if typed, ok := cache.(Cache[string, V]); ok {
+		return typed
+	}
+	return &typedAdapter[V]{cache: cache}
+}
+
+// Untyped adapts a typed cache to interfaces.Cache. Setting a value that is
+// not a V returns ErrTypeMismatch.
+func Untyped[V any](cache Cache[string, V]) interfaces.Cache {
+	if untyped, ok := cache.(interfaces.Cache); ok {
+		return untyped
+	}
+	return &untypedAdapter[V]{cache: cache}
+}
+
+type typedAdapter[V any] struct {
+	cache interfaces.Cache
+}
+
+func (a *typedAdapter[V]) Get(key string) (V, error) {
+	var zero V
+	value, err := a.cache.Get(key)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := value.(V)
+	if !ok {
+		return zero, ErrTypeMismatch
+	}
+	return typed, nil
+}
+
+func (a *typedAdapter[V]) Set(key string, value V) error {
+	return a.cache.Set(key, value)
+}
+
+func (a *typedAdapter[V]) Delete(key string) error {
+	return a.cache.Delete(key)
+}
+
+func (a *typedAdapter[V]) Clear() error {
+	return a.cache.Clear()
+}
+
+func (a *typedAdapter[V]) SetWithTTL(key string, value V, ttl time.Duration) error {
+	return a.cache.SetWithTTL(key, value, ttl)
+}
+
+func (a *typedAdapter[V]) TTL(key string) (time.Duration, error) {
+	return a.cache.TTL(key)
+}
+
+func (a *typedAdapter[V]) Touch(key string, ttl time.Duration) error {
+	return a.cache.Touch(key, ttl)
+}
+
+func (a *typedAdapter[V]) Persist(key string) error {
+	return a.cache.Persist(key)
+}
+
+func (a *typedAdapter[V]) Close() error {
+	return a.cache.Close()
+}
+
+type untypedAdapter[V any] struct {
+	cache Cache[string, V]
+}
+
+func (a *untypedAdapter[V]) Get(key string) (interface{}, error) {
+	value, err := a.cache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (a *untypedAdapter[V]) Set(key string, value interface{}) error {
+	typed, ok := value.(V)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	return a.cache.Set(key, typed)
+}
+
+func (a *untypedAdapter[V]) Delete(key string) error {
+	return a.cache.Delete(key)
+}
+
+func (a *untypedAdapter[V]) Clear() error {
+	return a.cache.Clear()
+}
+
+func (a *untypedAdapter[V]) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	typed, ok := value.(V)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	return a.cache.SetWithTTL(key, typed, ttl)
+}
+
+func (a *untypedAdapter[V]) TTL(key string) (time.Duration, error) {
+	return a.cache.TTL(key)
+}
+
+func (a *untypedAdapter[V]) Touch(key string, ttl time.Duration) error {
+	return a.cache.Touch(key, ttl)
+}
+
+func (a *untypedAdapter[V]) Persist(key string) error {
+	return a.cache.Persist(key)
+}
+
+func (a *untypedAdapter[V]) Close() error {
+	return a.cache.Close()
+}
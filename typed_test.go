@@ -0,0 +1,72 @@
+// File: typed_test.go
+
+package cachefy
+
+import (
+	"testing"
+	"time"
+)
+
+type session struct {
+	UserID int
+	Token  string
+}
+
+func TestNewTypedCache(t *testing.T) {
+	for _, backend := range []string{"syncmap", "rwmutex", "sharded", "tinylfu"} {
+		config := CacheConfig{
+			DefaultTTL:    5 * time.Minute,
+			Backend:       backend,
+			Shards:        4,
+			ShardCapacity: 100,
+			Capacity:      100,
+		}
+
+		cache, err := NewTypedCache[int, session](config)
+		if err != nil {
+			t.Fatalf("%s: failed to create cache: %v", backend, err)
+		}
+
+		err = cache.Set(42, session{UserID: 42, Token: "abc"})
+		if err != nil {
+			t.Fatalf("%s: failed to set cache value: %v", backend, err)
+		}
+
+		value, err := cache.Get(42)
+		if err != nil || value.Token != "abc" {
+			t.Fatalf("%s: failed to get cache value: %v, error: %v", backend, value, err)
+		}
+	}
+}
+
+func TestTypedAdapters(t *testing.T) {
+	untyped, err := NewCache(CacheConfig{DefaultTTL: 5 * time.Minute, Backend: "rwmutex"})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	untyped.Set("count", 1)
+	untyped.Set("name", "cachefy")
+
+	typed := Typed[int](untyped)
+	if value, err := typed.Get("count"); err != nil || value != 1 {
+		t.Errorf("Expected 1, got %v, error: %v", value, err)
+	}
+	if _, err := typed.Get("name"); err != ErrTypeMismatch {
+		t.Errorf("Expected ErrTypeMismatch, got %v", err)
+	}
+
+	ints, err := NewTypedCache[string, int](CacheConfig{DefaultTTL: 5 * time.Minute, Backend: "rwmutex"})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	adapted := Untyped[int](ints)
+	if err := adapted.Set("name", "cachefy"); err != ErrTypeMismatch {
+		t.Errorf("Expected ErrTypeMismatch, got %v", err)
+	}
+	if err := adapted.Set("count", 2); err != nil {
+		t.Errorf("Failed to set cache value: %v", err)
+	}
+	if value, err := ints.Get("count"); err != nil || value != 2 {
+		t.Errorf("Expected 2, got %v, error: %v", value, err)
+	}
+}